@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/rus-cert/socks-router/routing"
+)
+
+// subcommands: socks-router [flags] COMMAND [ARGS...]
+var commands = map[string]func(args []string) error{
+	"explain": explainCommand,
+	"import":  importCommand,
+	"learned": learnedCommand,
+	"profile": profileCommand,
+}
+
+func runCommand(args []string) {
+	if command, ok := commands[args[0]]; !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", args[0])
+		os.Exit(2)
+	} else if err := command(args[1:]); nil != err {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", args[0], err)
+		os.Exit(1)
+	}
+}
+
+// explain HOST:PORT...: show how requests would be routed
+func explainCommand(args []string) error {
+	if 0 == len(args) {
+		return fmt.Errorf("Usage: explain HOST:PORT...")
+	}
+	routingMap, err := routing.ReadMapFile(configFile)
+	if nil != err {
+		return err
+	}
+	routingMap.CheckConditions()
+	if name, err := readProfileFile(); nil == err {
+		routingMap.SetProfile(name)
+	}
+	for _, address := range args {
+		requested, err := routing.ParseAddress(address)
+		if nil != err {
+			return err
+		}
+		ad, _, err := routingMap.Prepare(context.Background(), address)
+		if nil != err {
+			return err
+		}
+		d, err := routingMap.Decide(context.Background(), "tcp", *ad)
+		if nil != err {
+			return err
+		}
+		fmt.Printf("%v:\n", requested.Address)
+		if ad.Address != requested.Address {
+			// @rewrite, @host (before)
+			fmt.Printf("  address: %v\n", ad.Address)
+		}
+		if 0 != len(d.Profile) {
+			fmt.Printf("  profile: %v\n", d.Profile)
+		}
+		fmt.Printf("  tables:  %v\n", strings.Join(d.Tables, " > "))
+		if nil != d.Route {
+			fmt.Printf("  rule:    %v\n", d.Route)
+		} else {
+			fmt.Printf("  rule:    (none)\n")
+		}
+		fmt.Printf("  target:  %v\n", d.TargetName())
+	}
+	return nil
+}
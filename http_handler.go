@@ -6,19 +6,25 @@ import (
 	"net/http"
 	"strings"
 
+	"golang.org/x/net/context"
+
 	"github.com/rus-cert/socks-router/connpeeker"
 	"github.com/rus-cert/socks-router/httpproxy"
 	"github.com/rus-cert/socks-router/log"
+	"github.com/rus-cert/socks-router/routing"
+	"github.com/rus-cert/socks-router/sniff"
 )
 
 type httpHandler struct {
 	httpListener *connpeeker.FakeListener
 	dialer       Dialer
+	sniffer      *sniff.Sniffer
 	server       *http.Server
 }
 
 type httpConnectHandler struct {
 	dialer  Dialer
+	sniffer *sniff.Sniffer
 	address string
 }
 
@@ -27,9 +33,22 @@ func (h httpConnectHandler) ServeConn(conn net.Conn) error {
 	pc := conn.(*connpeeker.PeekTCPConn)
 	// drop *all* read bytes so far; continue with underlying connection
 	pc.ReadBuffer = nil
+	ctx := routing.NewClientContext(context.Background(), conn)
+	ctx = routing.NewProtocolContext(ctx, "connect")
 
 	log.Debug.Printf("(bad http) CONNECT: %q", h.address)
-	if backend, err := h.dialer.Dial("tcp", h.address); nil != err {
+	if nil != h.sniffer && h.sniffer.Applies(h.address) {
+		if _, err := pc.Conn.Write([]byte("HTTP/1.0 200 OK\r\n\r\n")); nil != err {
+			return err
+		}
+		if backend, r, err := h.sniffer.Dial(ctx, pc.Conn, pc.Conn, "tcp", h.address); nil != err {
+			return err
+		} else {
+			defer backend.Close()
+			return httpproxy.Forward(pc.Conn, r, backend, backend)
+		}
+	}
+	if backend, err := h.dialer.DialContext(ctx, "tcp", h.address); nil != err {
 		pc.Conn.Write([]byte("HTTP/1.0 503 OK\r\n\r\n"))
 		return err
 	} else {
@@ -56,6 +75,7 @@ func (h httpHandler) Detect(peek []byte) (ConnHandler, error) {
 			// "bad" CONNECT (not really HTTP) request
 			return httpConnectHandler{
 				dialer:  h.dialer,
+				sniffer: h.sniffer,
 				address: fields[1],
 			}, nil
 		}
@@ -64,15 +84,16 @@ func (h httpHandler) Detect(peek []byte) (ConnHandler, error) {
 }
 
 // CreateHTTPHandler returns a ProtocolHandler to detect and handle HTTP
-// and CONNECT requests
-func CreateHTTPHandler(dialer Dialer) (ProtocolHandler, error) {
+// and CONNECT requests; sniffer is optional
+func CreateHTTPHandler(dialer Dialer, sniffer *sniff.Sniffer) (ProtocolHandler, error) {
 	// http.Server doesn't have a "ServeConn" method; it only supports
 	// the Listener interface, so pass connections through a "fake
 	// listener" (a simple queue)
 	httpListener := connpeeker.NewFakeListener()
 	server := &http.Server{
-		Handler:        httpproxy.HTTPProxy(dialer.Dial),
+		Handler:        httpproxy.HTTPProxy(dialer, sniffer),
 		MaxHeaderBytes: 1 << 20,
+		ConnContext:    routing.NewClientContext,
 	}
 
 	// needs to poll listener in a separate thread; should exit on
@@ -82,6 +103,7 @@ func CreateHTTPHandler(dialer Dialer) (ProtocolHandler, error) {
 	return httpHandler{
 		httpListener: httpListener,
 		dialer:       dialer,
+		sniffer:      sniffer,
 		server:       server,
 	}, nil
 }
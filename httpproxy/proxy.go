@@ -1,28 +1,86 @@
 package httpproxy
 
 import (
+	"encoding/base64"
 	"errors"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"strings"
+	"sync"
 	"time"
 
+	"golang.org/x/net/context"
+
 	"github.com/rus-cert/socks-router/log"
+	"github.com/rus-cert/socks-router/routing"
+	"github.com/rus-cert/socks-router/sniff"
 )
 
+type Dialer interface {
+	// DialContext gets the request context (see http.Server.ConnContext
+	// and routing.NewHTTPRequestContext)
+	DialContext(ctx context.Context, network, addr string) (c net.Conn, err error)
+}
+
+// Router can be implemented by a Dialer to decide the route of plain
+// HTTP requests up front: returns the route name and a context for
+// DialContext to dial with that decision
+type Router interface {
+	Route(ctx context.Context, network, addr string) (context.Context, string)
+}
+
 type httpProxy struct {
-	dial         func(network, addr string) (c net.Conn, err error)
+	dial         func(ctx context.Context, network, addr string) (c net.Conn, err error)
+	router       Router
+	sniffer      *sniff.Sniffer
 	reverseProxy *httputil.ReverseProxy
 }
 
-func HTTPProxy(dial func(network, addr string) (c net.Conn, err error)) http.Handler {
+type routeKey int
+
+// routeContextKey is the key for the route name of a request
+const routeContextKey routeKey = 0
+
+// separate connection pools for each route: a connection dialed for one
+// route must not be reused for requests to the same host taking another
+// route
+type routedTransport struct {
+	dial       func(ctx context.Context, network, addr string) (c net.Conn, err error)
+	mutex      sync.Mutex
+	transports map[string]*http.Transport
+}
+
+func (t *routedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route, _ := req.Context().Value(routeContextKey).(string)
+	t.mutex.Lock()
+	transport, ok := t.transports[route]
+	if !ok {
+		transport = &http.Transport{
+			DialContext:           t.dial,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		}
+		t.transports[route] = transport
+	}
+	t.mutex.Unlock()
+	return transport.RoundTrip(req)
+}
+
+// HTTPProxy handles CONNECT and plain HTTP proxy requests; if dialer
+// implements Router plain HTTP requests are routed individually.
+// sniffer is optional; if set it is used for CONNECT requests with IP
+// addresses
+func HTTPProxy(dialer Dialer, sniffer *sniff.Sniffer) http.Handler {
+	router, _ := dialer.(Router)
 	return &httpProxy{
-		dial: dial,
+		dial:    dialer.DialContext,
+		router:  router,
+		sniffer: sniffer,
 		reverseProxy: &httputil.ReverseProxy{
-			Transport: &http.Transport{
-				Dial:                  dial,
-				TLSHandshakeTimeout:   10 * time.Second,
-				ExpectContinueTimeout: 1 * time.Second,
+			Transport: &routedTransport{
+				dial:       dialer.DialContext,
+				transports: make(map[string]*http.Transport),
 			},
 			Director: func(req *http.Request) {
 				req.RequestURI = ""
@@ -43,7 +101,30 @@ func noRedirect(req *http.Request, via []*http.Request) error {
 	return errNoRedirect
 }
 
+// user name from Proxy-Authorization (basic auth)
+func proxyAuthUser(r *http.Request) string {
+	auth := r.Header.Get("Proxy-Authorization")
+	const prefix = "Basic "
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return ""
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(auth[len(prefix):]); nil != err {
+		return ""
+	} else if colon := strings.IndexByte(string(decoded), ':'); -1 == colon {
+		return ""
+	} else {
+		return string(decoded[:colon])
+	}
+}
+
 func (p *httpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := routing.NewUserContext(r.Context(), proxyAuthUser(r))
+	if "CONNECT" == r.Method {
+		ctx = routing.NewProtocolContext(ctx, "http-connect")
+	} else {
+		ctx = routing.NewProtocolContext(ctx, "http")
+	}
+	r = r.WithContext(ctx)
 	if "CONNECT" == r.Method {
 		hj, ok := w.(http.Hijacker)
 		if !ok {
@@ -53,7 +134,11 @@ func (p *httpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 
 		log.Debug.Printf("http CONNECT: %q", r.RequestURI)
-		backend, err := p.dial("tcp", r.RequestURI)
+		if nil != p.sniffer && p.sniffer.Applies(r.RequestURI) {
+			p.connectSniffed(w, hj, r)
+			return
+		}
+		backend, err := p.dial(r.Context(), "tcp", r.RequestURI)
 		if nil != err {
 			http.Error(w, err.Error(), 503)
 			return
@@ -78,6 +163,49 @@ func (p *httpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		log.Debug.Printf("forwarding HTTP request for %q", r.RequestURI)
-		p.reverseProxy.ServeHTTP(w, r)
+		ctx := routing.NewHTTPRequestContext(r.Context(), r)
+		if nil != p.router {
+			var route string
+			ctx, route = p.router.Route(ctx, "tcp", requestAddress(r))
+			ctx = context.WithValue(ctx, routeContextKey, route)
+		}
+		p.reverseProxy.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// reply to CONNECT first, then sniff for the hostname
+func (p *httpProxy) connectSniffed(w http.ResponseWriter, hj http.Hijacker, r *http.Request) {
+	conn, bufrw, err := hj.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	bufrw.WriteString(r.Proto + " 200 OK\r\n\r\n")
+	bufrw.Flush()
+
+	backend, clientReader, err := p.sniffer.Dial(r.Context(), conn, bufrw, "tcp", r.RequestURI)
+	if nil != err {
+		log.Error.Printf("failed CONNECT to %q: %v", r.RequestURI, err)
+		return
+	}
+	defer backend.Close()
+
+	log.Debug.Printf("forwarding CONNECT to %q", r.RequestURI)
+	if err := Forward(conn, clientReader, backend, backend); nil != err {
+		log.Error.Printf("failed CONNECT to %q: %v", r.RequestURI, err)
+	} else {
+		log.Debug.Printf("done CONNECT: %q", r.RequestURI)
+	}
+}
+
+// host:port of a plain HTTP request
+func requestAddress(r *http.Request) string {
+	if _, _, err := net.SplitHostPort(r.URL.Host); nil == err {
+		return r.URL.Host
+	} else if "https" == r.URL.Scheme {
+		return net.JoinHostPort(r.URL.Hostname(), "443")
+	} else {
+		return net.JoinHostPort(r.URL.Hostname(), "80")
 	}
 }
@@ -0,0 +1,81 @@
+package httpproxy
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/rus-cert/socks-router/routing"
+)
+
+type testRouteKey struct{}
+
+// routes requests for paths below /vpn/ to "vpn"; all connections go to
+// the backend
+type testRouter struct {
+	backend string
+
+	mutex sync.Mutex
+	dials []string // route of each connection
+}
+
+func (r *testRouter) Route(ctx context.Context, network, addr string) (context.Context, string) {
+	route := "default"
+	if req, ok := routing.HTTPRequestFromContext(ctx); ok && strings.HasPrefix(req.URL.Path, "/vpn/") {
+		route = "vpn"
+	}
+	if protocol, _ := routing.ProtocolFromContext(ctx); "http" != protocol {
+		route = "wrong protocol " + protocol
+	}
+	return context.WithValue(ctx, testRouteKey{}, route), route
+}
+
+func (r *testRouter) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	route, _ := ctx.Value(testRouteKey{}).(string)
+	r.mutex.Lock()
+	r.dials = append(r.dials, route)
+	r.mutex.Unlock()
+	return net.Dial(network, r.backend)
+}
+
+func TestRoutedRequests(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + r.URL.Path))
+	}))
+	defer backend.Close()
+	router := &testRouter{backend: backend.Listener.Addr().String()}
+	proxy := httptest.NewServer(HTTPProxy(router, nil))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	// same host, different routes
+	paths := []string{"/vpn/1", "/web/1", "/vpn/2", "/web/2", "/vpn/3"}
+	for _, path := range paths {
+		resp, err := client.Get("http://intranet.example" + path)
+		if nil != err {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if nil != err {
+			t.Fatal(err)
+		} else if "intranet.example"+path != string(body) {
+			t.Errorf("%v: got %q", path, body)
+		}
+	}
+
+	// one connection per route, reused for the requests taking it
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	if dials := strings.Join(router.dials, " "); "vpn default" != dials {
+		t.Errorf("got connections for %q, want \"vpn default\"", dials)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rus-cert/socks-router/importer"
+)
+
+var importFormats = map[string]func(in io.Reader) (*importer.Result, error){
+	"switchyomega": importer.SwitchyOmega,
+	"foxyproxy":    importer.FoxyProxy,
+	"pac":          importer.PAC,
+}
+
+// import --from FORMAT FILE: print equivalent routes; warnings about
+// settings that can't be translated go to stderr
+func importCommand(args []string) error {
+	var formats []string
+	for name := range importFormats {
+		formats = append(formats, name)
+	}
+	sort.Strings(formats)
+	usage := fmt.Errorf("Usage: import --from %v FILE", strings.Join(formats, "|"))
+
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	from := flags.String("from", "", "format of the imported file ("+strings.Join(formats, ", ")+")")
+	if err := flags.Parse(args); nil != err || 1 != flags.NArg() {
+		return usage
+	}
+	convert, ok := importFormats[*from]
+	if !ok {
+		return usage
+	}
+	f, err := os.Open(flags.Arg(0))
+	if nil != err {
+		return err
+	}
+	defer f.Close()
+	result, err := convert(f)
+	if nil != err {
+		return err
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", warning)
+	}
+	_, err = result.WriteTo(os.Stdout)
+	return err
+}
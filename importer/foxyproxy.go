@@ -0,0 +1,229 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+type foxyPattern struct {
+	Title   string
+	Pattern string
+	Regex   bool
+	Active  bool
+}
+
+type foxyProxy struct {
+	ID      string
+	Title   string
+	Type    string // socks5, http, https, direct, ...
+	Host    string
+	Port    string
+	Auth    bool
+	Active  bool
+	Include []foxyPattern
+	Exclude []foxyPattern
+}
+
+// FoxyProxy v8 export
+type foxyExportV8 struct {
+	Mode string `json:"mode"`
+	Data []struct {
+		ID       string          `json:"id"`
+		Active   bool            `json:"active"`
+		Title    string          `json:"title"`
+		Type     string          `json:"type"`
+		Hostname string          `json:"hostname"`
+		Port     json.RawMessage `json:"port"` // string or number
+		Username string          `json:"username"`
+		Include  []struct {
+			Type    string `json:"type"`
+			Title   string `json:"title"`
+			Pattern string `json:"pattern"`
+			Active  bool   `json:"active"`
+		} `json:"include"`
+		Exclude []struct {
+			Type    string `json:"type"`
+			Title   string `json:"title"`
+			Pattern string `json:"pattern"`
+			Active  bool   `json:"active"`
+		} `json:"exclude"`
+	} `json:"data"`
+}
+
+type foxyPatternV7 struct {
+	Title   string `json:"title"`
+	Pattern string `json:"pattern"`
+	Type    int    `json:"type"` // 1: wildcard, 2: regex
+	Active  bool   `json:"active"`
+}
+
+// FoxyProxy v7 export
+type foxyExportV7 struct {
+	Mode          string `json:"mode"`
+	ProxySettings []struct {
+		ID            string          `json:"id"`
+		Title         string          `json:"title"`
+		Type          int             `json:"type"` // 1: HTTP, 2: HTTPS, 3: SOCKS5, 4: SOCKS4, 5: none
+		Address       string          `json:"address"`
+		Port          int             `json:"port"`
+		Username      string          `json:"username"`
+		Active        bool            `json:"active"`
+		WhitePatterns []foxyPatternV7 `json:"whitePatterns"`
+		BlackPatterns []foxyPatternV7 `json:"blackPatterns"`
+	} `json:"proxySettings"`
+}
+
+var foxyTypesV7 = map[int]string{1: "http", 2: "https", 3: "socks5", 4: "socks4", 5: "direct"}
+
+func foxyPatternsV7(patterns []foxyPatternV7) []foxyPattern {
+	var result []foxyPattern
+	for _, p := range patterns {
+		result = append(result, foxyPattern{Title: p.Title, Pattern: p.Pattern, Regex: 2 == p.Type, Active: p.Active})
+	}
+	return result
+}
+
+// both export formats
+func parseFoxyProxy(data []byte) (string, []foxyProxy, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); nil != err {
+		return "", nil, err
+	}
+	var proxies []foxyProxy
+	if _, ok := probe["proxySettings"]; ok {
+		var export foxyExportV7
+		if err := json.Unmarshal(data, &export); nil != err {
+			return "", nil, err
+		}
+		for _, p := range export.ProxySettings {
+			proxies = append(proxies, foxyProxy{
+				ID:      p.ID,
+				Title:   p.Title,
+				Type:    foxyTypesV7[p.Type],
+				Host:    p.Address,
+				Port:    fmt.Sprint(p.Port),
+				Auth:    0 != len(p.Username),
+				Active:  p.Active,
+				Include: foxyPatternsV7(p.WhitePatterns),
+				Exclude: foxyPatternsV7(p.BlackPatterns),
+			})
+		}
+		return export.Mode, proxies, nil
+	}
+	var export foxyExportV8
+	if err := json.Unmarshal(data, &export); nil != err {
+		return "", nil, err
+	} else if nil == export.Data {
+		return "", nil, fmt.Errorf("No proxies found")
+	}
+	for _, p := range export.Data {
+		proxy := foxyProxy{
+			ID:     p.ID,
+			Title:  p.Title,
+			Type:   p.Type,
+			Host:   p.Hostname,
+			Port:   strings.Trim(string(p.Port), `"`),
+			Auth:   0 != len(p.Username),
+			Active: p.Active,
+		}
+		for _, i := range p.Include {
+			proxy.Include = append(proxy.Include, foxyPattern{Title: i.Title, Pattern: i.Pattern, Regex: "regex" == i.Type, Active: i.Active})
+		}
+		for _, e := range p.Exclude {
+			proxy.Exclude = append(proxy.Exclude, foxyPattern{Title: e.Title, Pattern: e.Pattern, Regex: "regex" == e.Type, Active: e.Active})
+		}
+		proxies = append(proxies, proxy)
+	}
+	return export.Mode, proxies, nil
+}
+
+// FoxyProxy converts a FoxyProxy export (v7 or v8 format).  In pattern
+// mode the proxies are tried in order: a proxy is used for a request
+// matching one of its include (white) patterns and none of its exclude
+// (black) patterns.
+func FoxyProxy(in io.Reader) (*Result, error) {
+	data, err := ioutil.ReadAll(in)
+	if nil != err {
+		return nil, err
+	}
+	mode, proxies, err := parseFoxyProxy(data)
+	if nil != err {
+		return nil, fmt.Errorf("Invalid FoxyProxy export: %v", err)
+	}
+	r := &Result{}
+	switch mode {
+	case "disable", "disabled", "direct":
+		r.add("# FoxyProxy disabled: everything goes direct")
+		return r, nil
+	case "patterns", "pattern":
+		r.add("# FoxyProxy patterns")
+		for _, p := range proxies {
+			if !p.Active {
+				continue
+			}
+			target, ok := foxyTarget(r, p)
+			if !ok {
+				continue
+			}
+			var excludes []string
+			for _, pattern := range p.Exclude {
+				if match := foxyMatch(r, p, pattern); 0 != len(match) {
+					excludes = append(excludes, "!"+match)
+				}
+			}
+			for _, pattern := range p.Include {
+				if match := foxyMatch(r, p, pattern); 0 != len(match) {
+					r.rule(append([]string{match}, excludes...), target)
+				}
+			}
+		}
+		return r, nil
+	}
+	// single proxy for everything
+	for _, p := range proxies {
+		if p.ID == mode || p.Title == mode {
+			r.add("# FoxyProxy: everything through %q", p.Title)
+			if target, ok := foxyTarget(r, p); ok {
+				r.catchAll(target)
+			}
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown FoxyProxy mode %q", mode)
+}
+
+func foxyTarget(r *Result, p foxyProxy) (string, bool) {
+	if p.Auth {
+		r.warn("Proxy %q: authentication not supported", p.Title)
+	}
+	if "direct" == p.Type {
+		return "direct", true
+	} else if !proxySchemes[p.Type] {
+		r.warn("Proxy %q: %v proxies not supported; skipped", p.Title, p.Type)
+		return "", false
+	}
+	return r.target(p.Title, proxyTarget(p.Type, p.Host, p.Port)), true
+}
+
+// wildcard pattern ("*.example.com", "*://*.example.com/*",
+// "example.com:8080"); "" for patterns that can't be translated
+func foxyMatch(r *Result, p foxyProxy, pattern foxyPattern) string {
+	if !pattern.Active {
+		return ""
+	} else if pattern.Regex {
+		r.warn("Proxy %q: regular expression %q not supported", p.Title, pattern.Pattern)
+		return ""
+	}
+	host, pathIgnored := urlPatternHost(pattern.Pattern)
+	if pathIgnored {
+		r.warn("Proxy %q: path in %q ignored", p.Title, pattern.Pattern)
+	}
+	match := hostPortWildcard(host, true)
+	if 0 == len(match) {
+		r.warn("Proxy %q: can't translate pattern %q", p.Title, pattern.Pattern)
+	}
+	return match
+}
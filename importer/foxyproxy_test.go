@@ -0,0 +1,92 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFoxyProxy(t *testing.T) {
+	tests := []struct {
+		name     string
+		export   string
+		lines    []string
+		warnings []string
+	}{
+		{
+			"v8 patterns",
+			`{"mode": "pattern", "data": [
+				{"active": true, "title": "Tor", "type": "socks5", "hostname": "127.0.0.1", "port": "9050", "include": [
+					{"type": "wildcard", "pattern": "*.onion", "active": true},
+					{"type": "wildcard", "pattern": "*://*.example.org/*", "active": true},
+					{"type": "regex", "pattern": "^x", "active": true},
+					{"type": "wildcard", "pattern": "*.inactive", "active": false}],
+				"exclude": [{"type": "wildcard", "pattern": "www.example.org", "active": true}]},
+				{"active": false, "title": "Off", "type": "http", "hostname": "10.0.0.1", "port": "3128", "include": [
+					{"type": "wildcard", "pattern": "*", "active": true}]},
+				{"active": true, "title": "Work", "type": "http", "hostname": "10.0.0.2", "port": "3128", "username": "u", "password": "p", "include": [
+					{"type": "wildcard", "pattern": "*.corp:8080", "active": true},
+					{"type": "wildcard", "pattern": "https://intra.corp/wiki/*", "active": true}]}]}`,
+			[]string{
+				"@target Tor socks5://127.0.0.1:9050",
+				"@target Work http://10.0.0.2:3128",
+				"",
+				"# FoxyProxy patterns",
+				".onion,!www.example.org Tor",
+				".example.org,!www.example.org Tor",
+				".corp:8080 Work",
+				"intra.corp Work",
+			},
+			[]string{"regular expression", "authentication not supported", "path in"},
+		},
+		{
+			"v7 single proxy",
+			`{"mode": "abc", "proxySettings": [
+				{"id": "abc", "title": "My Proxy", "type": 3, "address": "::1", "port": 1080, "active": true, "whitePatterns": [], "blackPatterns": []}]}`,
+			[]string{
+				"@target My-Proxy socks5://[::1]:1080",
+				"",
+				`# FoxyProxy: everything through "My Proxy"`,
+				"* My-Proxy",
+				"0.0.0.0/0 My-Proxy",
+				"::/0 My-Proxy",
+			},
+			nil,
+		},
+		{
+			"v7 patterns",
+			`{"mode": "patterns", "proxySettings": [
+				{"id": "abc", "title": "My Proxy", "type": 1, "address": "proxy", "port": 3128, "active": true,
+				"whitePatterns": [{"title": "all", "pattern": "*.example.com", "type": 1, "protocols": 1, "active": true}],
+				"blackPatterns": [{"title": "local", "pattern": "192.168.*", "type": 1, "protocols": 1, "active": true}]}]}`,
+			[]string{
+				"@target My-Proxy http://proxy:3128",
+				"",
+				"# FoxyProxy patterns",
+				".example.com,!192.168.0.0/16 My-Proxy",
+			},
+			nil,
+		},
+		{
+			"disabled",
+			`{"mode": "disable", "data": []}`,
+			[]string{"# FoxyProxy disabled: everything goes direct"},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		r, err := FoxyProxy(strings.NewReader(test.export))
+		checkLines(t, test.name, convertedLines(t, r, err), test.lines)
+		checkWarnings(t, test.name, r.Warnings, test.warnings)
+	}
+}
+
+func TestFoxyProxyInvalid(t *testing.T) {
+	for _, export := range []string{
+		`{"mode": "nonexistent", "data": []}`,
+		`not json`,
+	} {
+		if _, err := FoxyProxy(strings.NewReader(export)); nil == err {
+			t.Errorf("no error for %v", export)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Package importer converts proxy configurations of browser extensions
+// (and simple PAC files) into routes.
+package importer
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Result of a conversion: a routes file and everything that couldn't
+// be translated
+type Result struct {
+	Targets  []string // "@target" lines
+	Lines    []string // rules, comments and other directives
+	Warnings []string
+
+	targetNames map[string]bool
+}
+
+// each warning once
+func (r *Result) warn(format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	for _, w := range r.Warnings {
+		if w == warning {
+			return
+		}
+	}
+	r.Warnings = append(r.Warnings, warning)
+}
+
+func (r *Result) add(format string, args ...interface{}) {
+	r.Lines = append(r.Lines, fmt.Sprintf(format, args...))
+}
+
+// defines a named target (once); returns the name
+func (r *Result) target(name, target string) string {
+	name = targetName(name)
+	if nil == r.targetNames {
+		r.targetNames = make(map[string]bool)
+	}
+	if !r.targetNames[name] {
+		r.targetNames[name] = true
+		r.Targets = append(r.Targets, fmt.Sprintf("@target %v %v", name, target))
+	}
+	return name
+}
+
+// rule for all matches; "*" (first) includes IP addresses
+func (r *Result) rule(matches []string, target string) {
+	if "*" == matches[0] {
+		for _, all := range []string{"*", "0.0.0.0/0", "::/0"} {
+			matches[0] = all
+			r.add("%-12v %v", strings.Join(matches, ","), target)
+		}
+		return
+	}
+	r.add("%-12v %v", strings.Join(matches, ","), target)
+}
+
+// rules for all requests (hostnames and IP addresses)
+func (r *Result) catchAll(target string) {
+	r.rule([]string{"*"}, target)
+}
+
+// WriteTo writes the routes file
+func (r *Result) WriteTo(w io.Writer) (int64, error) {
+	var lines []string
+	lines = append(lines, r.Targets...)
+	if 0 != len(r.Targets) {
+		lines = append(lines, "")
+	}
+	lines = append(lines, r.Lines...)
+	n, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return int64(n), err
+}
+
+var invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// names usable for targets and tables
+func targetName(name string) string {
+	name = strings.Trim(invalidNameChars.ReplaceAllString(name, "-"), "-")
+	switch name {
+	case "", "direct", "reject", "jump", "return":
+		name = "proxy-" + name
+	}
+	return name
+}
+
+// proxies usable as target (scheme of the target URL)
+var proxySchemes = map[string]bool{"socks5": true, "http": true, "https": true}
+
+// target URL of a proxy
+func proxyTarget(scheme, host string, port interface{}) string {
+	return fmt.Sprintf("%v://%v", scheme, net.JoinHostPort(host, fmt.Sprint(port)))
+}
+
+var ipWildcard = regexp.MustCompile(`^(\d+)((?:\.\d+){0,2})\.\*$`)
+
+// converts a host wildcard ("*.example.com", "example.com", "10.*",
+// "*"); apex decides whether "*.example.com" matches example.com as
+// well.  Returns "" if the pattern can't be translated.
+func hostWildcard(pattern string, apex bool) string {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if "*" == pattern {
+		return "*"
+	} else if m := ipWildcard.FindStringSubmatch(pattern); nil != m {
+		octets := strings.Split(pattern[:len(pattern)-2], ".")
+		for len(octets) < 4 {
+			octets = append(octets, "0")
+		}
+		return fmt.Sprintf("%v/%v", strings.Join(octets, "."), 8*(1+strings.Count(m[2], ".")))
+	} else if strings.HasPrefix(pattern, "*.") && !strings.Contains(pattern[2:], "*") {
+		if apex {
+			return pattern[1:]
+		}
+		return fmt.Sprintf("%v,!%v", pattern[1:], pattern[2:])
+	} else if !strings.ContainsAny(pattern, "*?") {
+		if ip := net.ParseIP(pattern); nil != ip && nil == ip.To4() {
+			return "[" + pattern + "]"
+		}
+		return pattern
+	}
+	return ""
+}
+
+// netmask to prefix length; -1 for invalid (non-contiguous) masks
+func maskBits(mask string) int {
+	ip := net.ParseIP(mask)
+	if nil == ip {
+		return -1
+	}
+	var m net.IPMask
+	if ipv4 := ip.To4(); nil != ipv4 {
+		m = net.IPMask(ipv4)
+	} else {
+		m = net.IPMask(ip)
+	}
+	if ones, bits := m.Size(); 0 == bits {
+		return -1
+	} else {
+		return ones
+	}
+}
+
+// host (with port) of an URL wildcard pattern ("*://*.example.com/*");
+// returns whether a path was ignored
+func urlPatternHost(pattern string) (string, bool) {
+	host := pattern
+	if scheme := strings.Index(host, "://"); -1 != scheme {
+		host = host[scheme+3:]
+	}
+	if slash := strings.IndexByte(host, '/'); -1 != slash {
+		path := host[slash:]
+		return host[:slash], "/" != path && "/*" != path
+	}
+	return host, false
+}
+
+// wildcard with optional port ("example.com:8080", "*.example.com:*");
+// "" if it can't be translated
+func hostPortWildcard(pattern string, apex bool) string {
+	host, port := pattern, ""
+	if colon := strings.LastIndexByte(host, ':'); -1 != colon && 1 == strings.Count(host, ":") {
+		host, port = host[:colon], host[colon+1:]
+	}
+	match := hostWildcard(host, apex)
+	if 0 != len(match) && 0 != len(port) && "*" != port {
+		if strings.Contains(match, ",") {
+			// "a,!b": port applies to both
+			parts := strings.Split(match, ",")
+			for i := range parts {
+				parts[i] += ":" + port
+			}
+			return strings.Join(parts, ",")
+		}
+		match += ":" + port
+	}
+	return match
+}
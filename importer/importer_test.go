@@ -0,0 +1,130 @@
+package importer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rus-cert/socks-router/routing"
+)
+
+// lines of the converted routes file (with normalized spacing); the file
+// needs to be valid
+func convertedLines(t *testing.T, r *Result, err error) []string {
+	t.Helper()
+	if nil != err {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	if _, err := routing.ReadMap(strings.NewReader(buf.String())); nil != err {
+		t.Fatalf("invalid routes (%v):\n%v", err, buf.String())
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+	return lines
+}
+
+func checkLines(t *testing.T, name string, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("%v: got\n%v\nwant\n%v", name, strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// every expected warning contains one of the parts
+func checkWarnings(t *testing.T, name string, warnings []string, want []string) {
+	t.Helper()
+	if len(warnings) != len(want) {
+		t.Errorf("%v: warnings %q, want %q", name, warnings, want)
+		return
+	}
+	for i, part := range want {
+		if !strings.Contains(warnings[i], part) {
+			t.Errorf("%v: warning %q doesn't contain %q", name, warnings[i], part)
+		}
+	}
+}
+
+func TestHostWildcard(t *testing.T) {
+	tests := []struct {
+		pattern string
+		apex    bool
+		match   string
+	}{
+		{"*", false, "*"},
+		{"*.Example.com", true, ".example.com"},
+		{"*.example.com", false, ".example.com,!example.com"},
+		{"example.com", false, "example.com"},
+		{"10.*", false, "10.0.0.0/8"},
+		{"192.168.*", false, "192.168.0.0/16"},
+		{"192.168.1.*", false, "192.168.1.0/24"},
+		{"2001:db8::1", false, "[2001:db8::1]"},
+		{"192.0.2.1", false, "192.0.2.1"},
+		{"www.*.com", false, ""},
+		{"ex?mple.com", false, ""},
+		{"*.*.example.com", false, ""},
+	}
+	for _, test := range tests {
+		if match := hostWildcard(test.pattern, test.apex); match != test.match {
+			t.Errorf("hostWildcard(%q, %v) = %q, want %q", test.pattern, test.apex, match, test.match)
+		}
+	}
+}
+
+func TestHostPortWildcard(t *testing.T) {
+	tests := []struct {
+		pattern string
+		apex    bool
+		match   string
+	}{
+		{"example.com:8080", false, "example.com:8080"},
+		{"*.example.com:*", true, ".example.com"},
+		{"*.example.com:443", false, ".example.com:443,!example.com:443"},
+		{"2001:db8::1", false, "[2001:db8::1]"},
+	}
+	for _, test := range tests {
+		if match := hostPortWildcard(test.pattern, test.apex); match != test.match {
+			t.Errorf("hostPortWildcard(%q, %v) = %q, want %q", test.pattern, test.apex, match, test.match)
+		}
+	}
+}
+
+func TestMaskBits(t *testing.T) {
+	tests := []struct {
+		mask string
+		bits int
+	}{
+		{"255.0.0.0", 8},
+		{"255.255.255.0", 24},
+		{"255.255.255.255", 32},
+		{"0.0.0.0", 0},
+		{"255.0.255.0", -1},
+		{"ffff:ffff::", 32},
+		{"mask", -1},
+	}
+	for _, test := range tests {
+		if bits := maskBits(test.mask); bits != test.bits {
+			t.Errorf("maskBits(%q) = %v, want %v", test.mask, bits, test.bits)
+		}
+	}
+}
+
+func TestTargetName(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"Work Proxy", "Work-Proxy"},
+		{"proxy.example.com:3128", "proxy.example.com-3128"},
+		{"direct", "proxy-direct"},
+		{"--", "proxy-"},
+	}
+	for _, test := range tests {
+		if target := targetName(test.name); target != test.target {
+			t.Errorf("targetName(%q) = %q, want %q", test.name, target, test.target)
+		}
+	}
+}
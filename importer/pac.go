@@ -0,0 +1,343 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+var (
+	pacKeyword   = regexp.MustCompile(`\b(if|return|function)\b`)
+	pacReturn    = regexp.MustCompile(`^\s*return\s+(?:"([^"]*)"|'([^']*)'|(\w+))\s*;?`)
+	pacIfReturn  = regexp.MustCompile(`^\s*(\{)?\s*return\s+(?:"([^"]*)"|'([^']*)'|(\w+))\s*;?\s*(\})?`)
+	pacVar       = regexp.MustCompile(`\b(?:var|let|const)?\s*(\w+)\s*=\s*(?:"([^"]*)"|'([^']*)')\s*;`)
+	pacFunction  = regexp.MustCompile(`^function\s+(\w+)`)
+	pacString    = `\s*(?:"([^"]*)"|'([^']*)')\s*`
+	pacHost      = `\s*(?:host|dnsResolve\(\s*host\s*\)|\w+)\s*`
+	pacShExp     = regexp.MustCompile(`^shExpMatch\(\s*(host|url)\s*,` + pacString + `\)$`)
+	pacDomainIs  = regexp.MustCompile(`^dnsDomainIs\(\s*host\s*,` + pacString + `\)$`)
+	pacLocalIs   = regexp.MustCompile(`^localHostOrDomainIs\(\s*host\s*,` + pacString + `\)$`)
+	pacInNet     = regexp.MustCompile(`^isInNet\(` + pacHost + `,` + pacString + `,` + pacString + `\)$`)
+	pacHostEqual = regexp.MustCompile(`^(?:host\s*===?` + pacString + `|` + pacString + `===?\s*host)$`)
+)
+
+// removes comments (outside of strings)
+func pacStripComments(src string) string {
+	var out strings.Builder
+	var quote byte
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		if 0 != quote {
+			if '\\' == c && i+1 < len(src) {
+				out.WriteByte(c)
+				i++
+				c = src[i]
+			} else if quote == c {
+				quote = 0
+			}
+		} else if '"' == c || '\'' == c {
+			quote = c
+		} else if strings.HasPrefix(src[i:], "//") {
+			for i < len(src) && '\n' != src[i] {
+				i++
+			}
+		} else if strings.HasPrefix(src[i:], "/*") {
+			end := strings.Index(src[i+2:], "*/")
+			if -1 == end {
+				break
+			}
+			i += end + 3
+			continue
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
+
+// index after the bracket closing the one at start (-1 if unbalanced)
+func pacClosing(src string, start int) int {
+	open, close := src[start], byte(')')
+	if '{' == open {
+		close = '}'
+	}
+	depth := 0
+	var quote byte
+	for i := start; i < len(src); i++ {
+		c := src[i]
+		if 0 != quote {
+			if '\\' == c {
+				i++
+			} else if quote == c {
+				quote = 0
+			}
+		} else if '"' == c || '\'' == c {
+			quote = c
+		} else if open == c {
+			depth++
+		} else if close == c {
+			if depth--; 0 == depth {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// splits at top-level separators ("||", "&&")
+func pacSplit(expr, sep string) []string {
+	var parts []string
+	depth, last := 0, 0
+	var quote byte
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		if 0 != quote {
+			if '\\' == c {
+				i++
+			} else if quote == c {
+				quote = 0
+			}
+		} else if '"' == c || '\'' == c {
+			quote = c
+		} else if '(' == c {
+			depth++
+		} else if ')' == c {
+			depth--
+		} else if 0 == depth && strings.HasPrefix(expr[i:], sep) {
+			parts = append(parts, strings.TrimSpace(expr[last:i]))
+			last = i + len(sep)
+			i++
+		}
+	}
+	return append(parts, strings.TrimSpace(expr[last:]))
+}
+
+// strips parentheses around the whole expression
+func pacUnwrap(expr string) string {
+	for strings.HasPrefix(expr, "(") && len(expr) == pacClosing(expr, 0) {
+		expr = strings.TrimSpace(expr[1 : len(expr)-1])
+	}
+	return expr
+}
+
+func pacQuoted(m []string, i int) string {
+	return m[i] + m[i+1]
+}
+
+type pacConverter struct {
+	r    *Result
+	vars map[string]string
+	// isInNet warning shown
+	inNet bool
+}
+
+// PAC converts a (simple) proxy auto-config file: FindProxyForURL needs
+// to consist of "if (CONDITION) return RESULT;" statements (and a final
+// return) where conditions combine shExpMatch, dnsDomainIs,
+// localHostOrDomainIs, isInNet and host comparisons with ||, && and !.
+// Everything else is reported as a warning.
+func PAC(in io.Reader) (*Result, error) {
+	data, err := ioutil.ReadAll(in)
+	if nil != err {
+		return nil, err
+	}
+	src := pacStripComments(string(data))
+	if !strings.Contains(src, "FindProxyForURL") {
+		return nil, fmt.Errorf("No FindProxyForURL function in PAC file")
+	}
+	c := &pacConverter{r: &Result{}, vars: make(map[string]string)}
+	for _, m := range pacVar.FindAllStringSubmatch(src, -1) {
+		c.vars[m[1]] = pacQuoted(m, 2)
+	}
+	c.r.add("# PAC file")
+	for pos := 0; pos < len(src); {
+		loc := pacKeyword.FindStringIndex(src[pos:])
+		if nil == loc {
+			break
+		}
+		start := pos + loc[0]
+		switch src[start : pos+loc[1]] {
+		case "function":
+			pos = c.function(src, start)
+		case "if":
+			pos = c.ifStatement(src, start)
+		case "return":
+			if m := pacReturn.FindStringSubmatch(src[start:]); nil != m {
+				if target, ok := c.result(m[1:4]); ok {
+					c.r.catchAll(target)
+				}
+				// everything after the final return is unreachable
+				return c.r, nil
+			}
+			c.r.warn("Can't translate %q", strings.SplitN(src[start:], ";", 2)[0])
+			pos = start + len("return")
+		}
+	}
+	return c.r, nil
+}
+
+// skips helper functions
+func (c *pacConverter) function(src string, start int) int {
+	m := pacFunction.FindStringSubmatch(src[start:])
+	if nil != m && "FindProxyForURL" == m[1] {
+		return start + len(m[0])
+	}
+	if nil != m {
+		c.r.warn("Function %v ignored", m[1])
+	}
+	if brace := strings.IndexByte(src[start:], '{'); -1 != brace {
+		if end := pacClosing(src, start+brace); -1 != end {
+			return end
+		}
+	}
+	return len(src)
+}
+
+func (c *pacConverter) ifStatement(src string, start int) int {
+	paren := strings.IndexByte(src[start:], '(')
+	end := -1
+	if -1 != paren {
+		end = pacClosing(src, start+paren)
+	}
+	if -1 == end {
+		c.r.warn("Invalid if statement")
+		return len(src)
+	}
+	cond := strings.TrimSpace(src[start+paren+1 : end-1])
+	m := pacIfReturn.FindStringSubmatch(src[end:])
+	if nil == m || (0 != len(m[1])) != (0 != len(m[5])) {
+		c.r.warn("Can't translate if (%v): only \"return\" supported", cond)
+		// skip the block (or statement)
+		rest := strings.TrimLeft(src[end:], " \t\r\n")
+		skip := len(src) - len(rest)
+		if strings.HasPrefix(rest, "{") {
+			if close := pacClosing(src, skip); -1 != close {
+				return close
+			}
+		} else if semicolon := strings.IndexByte(rest, ';'); -1 != semicolon {
+			return skip + semicolon + 1
+		}
+		return len(src)
+	}
+	if target, ok := c.result(m[2:5]); ok {
+		for _, alternative := range pacSplit(pacUnwrap(cond), "||") {
+			if matches, ok := c.condition(alternative); ok {
+				c.r.rule(matches, target)
+			} else {
+				c.r.warn("Can't translate condition %q", alternative)
+			}
+		}
+	}
+	return end + len(m[0])
+}
+
+// conjunction of conditions
+func (c *pacConverter) condition(cond string) ([]string, bool) {
+	var matches []string
+	for _, part := range pacSplit(pacUnwrap(cond), "&&") {
+		negated := false
+		for part = pacUnwrap(part); strings.HasPrefix(part, "!"); part = pacUnwrap(part[1:]) {
+			negated = !negated
+		}
+		match, ok := c.atom(part)
+		if !ok {
+			return nil, false
+		} else if negated {
+			if strings.Contains(match, ",") || "*" == match {
+				return nil, false
+			}
+			match = "!" + match
+		}
+		matches = append(matches, strings.Split(match, ",")...)
+	}
+	return matches, true
+}
+
+func (c *pacConverter) atom(expr string) (string, bool) {
+	if m := pacShExp.FindStringSubmatch(expr); nil != m {
+		pattern := pacQuoted(m, 2)
+		if "url" == m[1] {
+			host, pathIgnored := urlPatternHost(pattern)
+			if pathIgnored {
+				return "", false
+			}
+			pattern = host
+		}
+		match := hostPortWildcard(pattern, false)
+		return match, 0 != len(match)
+	} else if m := pacDomainIs.FindStringSubmatch(expr); nil != m {
+		domain := strings.ToLower(pacQuoted(m, 1))
+		if strings.HasPrefix(domain, ".") {
+			// subdomains only
+			return fmt.Sprintf("%v,!%v", domain, domain[1:]), true
+		}
+		return "." + domain, true
+	} else if m := pacLocalIs.FindStringSubmatch(expr); nil != m {
+		return strings.ToLower(pacQuoted(m, 1)), true
+	} else if m := pacHostEqual.FindStringSubmatch(expr); nil != m {
+		return strings.ToLower(pacQuoted(m, 1) + pacQuoted(m, 3)), true
+	} else if m := pacInNet.FindStringSubmatch(expr); nil != m {
+		bits := maskBits(pacQuoted(m, 3))
+		if bits < 0 {
+			return "", false
+		}
+		if !c.inNet {
+			c.inNet = true
+			c.r.warn("isInNet only matches requests for IP addresses (hostnames are not resolved)")
+		}
+		return fmt.Sprintf("%v/%v", pacQuoted(m, 1), bits), true
+	}
+	return "", false
+}
+
+// PAC result ("SOCKS5 host:port; DIRECT") to target: first supported
+// entry
+func (c *pacConverter) result(m []string) (string, bool) {
+	result := m[0] + m[1]
+	if 0 != len(m[2]) {
+		if value, ok := c.vars[m[2]]; ok {
+			result = value
+		} else {
+			c.r.warn("Can't translate return %v", m[2])
+			return "", false
+		}
+	}
+	var entries []string
+	for _, entry := range strings.Split(result, ";") {
+		if entry = strings.TrimSpace(entry); 0 != len(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	for _, entry := range entries {
+		fields := strings.Fields(entry)
+		var target, scheme string
+		switch strings.ToUpper(fields[0]) {
+		case "DIRECT":
+			target = "direct"
+		case "SOCKS", "SOCKS5":
+			scheme = "socks5"
+		case "PROXY", "HTTP":
+			scheme = "http"
+		case "HTTPS":
+			scheme = "https"
+		default:
+			c.r.warn("%v proxies not supported (%q)", fields[0], entry)
+			continue
+		}
+		if 0 != len(scheme) {
+			if 2 != len(fields) {
+				c.r.warn("Invalid PAC result %q", entry)
+				continue
+			} else if "SOCKS" == strings.ToUpper(fields[0]) {
+				c.r.warn("SOCKS %v treated as SOCKS5", fields[1])
+			}
+			target = c.r.target(fields[1], scheme+"://"+fields[1])
+		}
+		if len(entries) > 1 {
+			c.r.warn("Fallbacks in %q not supported; using %v", result, target)
+		}
+		return target, true
+	}
+	return "", false
+}
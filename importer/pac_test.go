@@ -0,0 +1,89 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPAC(t *testing.T) {
+	tests := []struct {
+		name     string
+		pac      string
+		lines    []string
+		warnings []string
+	}{
+		{
+			"conditions",
+			`// example "quoted"
+			var tor = "SOCKS5 127.0.0.1:9050";
+			function helper(x) { return "DIRECT"; }
+			function FindProxyForURL(url, host) {
+				/* comment */
+				if (shExpMatch(host, "*.onion") || dnsDomainIs(host, ".i2p")) return tor;
+				if (isInNet(dnsResolve(host), "10.0.0.0", "255.0.0.0") && !(host == "10.1.1.1")) {
+					return "DIRECT";
+				}
+				if (isPlainHostName(host)) return "DIRECT";
+				if (shExpMatch(url, "http://*.http/*")) { var a = 1; return "PROXY x:1"; }
+				if (shExpMatch(host, "192.168.*")) return "PROXY proxy:3128; SOCKS x:1080";
+				return "SOCKS5 proxy:1080; DIRECT";
+				return "DIRECT";
+			}`,
+			[]string{
+				"@target 127.0.0.1-9050 socks5://127.0.0.1:9050",
+				"@target proxy-3128 http://proxy:3128",
+				"@target proxy-1080 socks5://proxy:1080",
+				"",
+				"# PAC file",
+				".onion,!onion 127.0.0.1-9050",
+				".i2p,!i2p 127.0.0.1-9050",
+				"10.0.0.0/8,!10.1.1.1 direct",
+				"192.168.0.0/16 proxy-3128",
+				"* proxy-1080",
+				"0.0.0.0/0 proxy-1080",
+				"::/0 proxy-1080",
+			},
+			[]string{
+				"Function helper ignored",
+				"isInNet only matches",
+				`condition "isPlainHostName(host)"`,
+				`only "return" supported`,
+				"Fallbacks in",
+				"Fallbacks in",
+			},
+		},
+		{
+			"results",
+			`function FindProxyForURL(url, host) {
+				if (localHostOrDomainIs(host, "intranet") || host === "wiki.corp") return 'HTTPS secure.example.com:443';
+				if (dnsDomainIs(host, "example.com")) return "DIRECT";
+				if (dnsDomainIs(host, "ftp.example.net")) return "FTP ftp.example.net:21";
+				return "SOCKS 127.0.0.1:1080";
+			}`,
+			[]string{
+				"@target secure.example.com-443 https://secure.example.com:443",
+				"@target 127.0.0.1-1080 socks5://127.0.0.1:1080",
+				"",
+				"# PAC file",
+				"intranet secure.example.com-443",
+				"wiki.corp secure.example.com-443",
+				".example.com direct",
+				"* 127.0.0.1-1080",
+				"0.0.0.0/0 127.0.0.1-1080",
+				"::/0 127.0.0.1-1080",
+			},
+			[]string{"FTP proxies not supported", "treated as SOCKS5"},
+		},
+	}
+	for _, test := range tests {
+		r, err := PAC(strings.NewReader(test.pac))
+		checkLines(t, test.name, convertedLines(t, r, err), test.lines)
+		checkWarnings(t, test.name, r.Warnings, test.warnings)
+	}
+}
+
+func TestPACInvalid(t *testing.T) {
+	if _, err := PAC(strings.NewReader(`var x = "FindProxy";`)); nil == err {
+		t.Error("no error without FindProxyForURL")
+	}
+}
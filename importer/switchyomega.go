@@ -0,0 +1,230 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+type omegaProxy struct {
+	Scheme string `json:"scheme"`
+	Host   string `json:"host"`
+	Port   int    `json:"port"`
+}
+
+type omegaCondition struct {
+	ConditionType string `json:"conditionType"`
+	Pattern       string `json:"pattern"`
+	IP            string `json:"ip"`
+	PrefixLength  int    `json:"prefixLength"`
+}
+
+type omegaProfile struct {
+	Name          string           `json:"name"`
+	ProfileType   string           `json:"profileType"`
+	FallbackProxy *omegaProxy      `json:"fallbackProxy"`
+	ProxyForHTTP  *omegaProxy      `json:"proxyForHttp"`
+	ProxyForHTTPS *omegaProxy      `json:"proxyForHttps"`
+	BypassList    []omegaCondition `json:"bypassList"`
+	Rules         []struct {
+		Condition   omegaCondition `json:"condition"`
+		ProfileName string         `json:"profileName"`
+	} `json:"rules"`
+	DefaultProfileName string `json:"defaultProfileName"`
+	PacScript          string `json:"pacScript"`
+}
+
+type switchyOmega struct {
+	r        *Result
+	profiles map[string]*omegaProfile
+	// switch profiles converted to tables (or being converted)
+	tables map[string]bool
+	queue  []string
+}
+
+// SwitchyOmega converts a SwitchyOmega backup (options .bak / JSON); the
+// startup profile (or the only switch profile) becomes the main table
+func SwitchyOmega(in io.Reader) (*Result, error) {
+	data, err := ioutil.ReadAll(in)
+	if nil != err {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); nil != err {
+		return nil, fmt.Errorf("Invalid SwitchyOmega backup: %v", err)
+	}
+	c := &switchyOmega{r: &Result{}, profiles: make(map[string]*omegaProfile), tables: make(map[string]bool)}
+	var switches []string
+	for key, value := range raw {
+		if !strings.HasPrefix(key, "+") {
+			continue
+		}
+		var p omegaProfile
+		if err := json.Unmarshal(value, &p); nil != err {
+			return nil, fmt.Errorf("Invalid SwitchyOmega profile %q: %v", key[1:], err)
+		}
+		c.profiles[p.Name] = &p
+		if "SwitchProfile" == p.ProfileType {
+			switches = append(switches, p.Name)
+		}
+	}
+	sort.Strings(switches)
+
+	var startup string
+	if s, ok := raw["-startupProfileName"]; ok {
+		json.Unmarshal(s, &startup)
+	}
+	if 0 == len(startup) || nil == c.profiles[startup] {
+		if 1 != len(switches) {
+			return nil, fmt.Errorf("No startup profile in SwitchyOmega backup")
+		}
+		startup = switches[0]
+	}
+
+	p := c.profiles[startup]
+	c.r.add("# SwitchyOmega profile %q", startup)
+	switch p.ProfileType {
+	case "SwitchProfile":
+		c.tables[startup] = true
+		c.switchRules(p)
+	case "FixedProfile":
+		c.fixedRules(p)
+	case "PacProfile":
+		c.pacRules(p)
+	default:
+		if target, ok := c.target(startup); ok {
+			c.r.catchAll(target)
+		}
+	}
+	// other switch profiles used by rules
+	for len(c.queue) > 0 {
+		name := c.queue[0]
+		c.queue = c.queue[1:]
+		c.r.add("")
+		c.r.add("@table %v", targetName(name))
+		c.switchRules(c.profiles[name])
+	}
+	return c.r, nil
+}
+
+// target for a profile: "direct", a named target or a table jump
+func (c *switchyOmega) target(name string) (string, bool) {
+	switch name {
+	case "direct":
+		return "direct", true
+	case "system":
+		c.r.warn("System proxy settings not supported; using direct instead")
+		return "direct", true
+	}
+	p, ok := c.profiles[name]
+	if !ok {
+		c.r.warn("Unknown profile %q", name)
+		return "", false
+	}
+	switch p.ProfileType {
+	case "FixedProfile":
+		if 0 != len(p.BypassList) {
+			c.r.warn("Profile %q: bypass list ignored when used in switch rules", name)
+		}
+		return c.proxy(p)
+	case "SwitchProfile":
+		if !c.tables[name] {
+			c.tables[name] = true
+			c.queue = append(c.queue, name)
+		}
+		return "jump " + targetName(name), true
+	default:
+		c.r.warn("Profile %q: %v profiles not supported", name, p.ProfileType)
+		return "", false
+	}
+}
+
+// named target for the proxy of a fixed profile
+func (c *switchyOmega) proxy(p *omegaProfile) (string, bool) {
+	proxy := p.FallbackProxy
+	if nil == proxy {
+		c.r.warn("Profile %q: only proxies for all schemes supported", p.Name)
+		return "", false
+	} else if nil != p.ProxyForHTTP || nil != p.ProxyForHTTPS {
+		c.r.warn("Profile %q: per-scheme proxies not supported; using %v://%v:%v", p.Name, proxy.Scheme, proxy.Host, proxy.Port)
+	}
+	if !proxySchemes[proxy.Scheme] {
+		c.r.warn("Profile %q: %v proxies not supported", p.Name, proxy.Scheme)
+		return "", false
+	}
+	return c.r.target(p.Name, proxyTarget(proxy.Scheme, proxy.Host, proxy.Port)), true
+}
+
+func (c *switchyOmega) switchRules(p *omegaProfile) {
+	for _, rule := range p.Rules {
+		match := c.condition(p.Name, rule.Condition)
+		if 0 == len(match) {
+			continue
+		}
+		if target, ok := c.target(rule.ProfileName); ok {
+			c.r.rule([]string{match}, target)
+		} else {
+			c.r.warn("Profile %q: skipped rule %v", p.Name, match)
+		}
+	}
+	if 0 == len(p.DefaultProfileName) || "direct" == p.DefaultProfileName {
+		return
+	} else if target, ok := c.target(p.DefaultProfileName); ok {
+		c.r.catchAll(target)
+	}
+}
+
+// bypass list goes direct, everything else through the proxy
+func (c *switchyOmega) fixedRules(p *omegaProfile) {
+	for _, cond := range p.BypassList {
+		if match := c.condition(p.Name, cond); 0 != len(match) {
+			c.r.rule([]string{match}, "direct")
+		}
+	}
+	if target, ok := c.proxy(p); ok {
+		c.r.catchAll(target)
+	}
+}
+
+// "" (and a warning) for conditions that can't be translated
+func (c *switchyOmega) condition(profile string, cond omegaCondition) string {
+	switch cond.ConditionType {
+	case "HostWildcardCondition", "BypassCondition":
+		if "<local>" == cond.Pattern {
+			c.r.warn("Profile %q: <local> (plain hostnames) not supported", profile)
+			return ""
+		} else if strings.Contains(cond.Pattern, "/") && "BypassCondition" == cond.ConditionType {
+			// CIDR in bypass list
+			return cond.Pattern
+		} else if match := hostWildcard(cond.Pattern, true); 0 != len(match) {
+			return match
+		}
+	case "IpCondition":
+		return fmt.Sprintf("%v/%v", cond.IP, cond.PrefixLength)
+	case "FalseCondition":
+		return ""
+	}
+	c.r.warn("Profile %q: can't translate %v %q", profile, cond.ConditionType, cond.Pattern)
+	return ""
+}
+
+// inline PAC scripts are converted like PAC files
+func (c *switchyOmega) pacRules(p *omegaProfile) {
+	if 0 == len(p.PacScript) {
+		c.r.warn("Profile %q: PAC URLs not supported", p.Name)
+		return
+	}
+	pac, err := PAC(strings.NewReader(p.PacScript))
+	if nil != err {
+		c.r.warn("Profile %q: %v", p.Name, err)
+		return
+	}
+	c.r.Targets = append(c.r.Targets, pac.Targets...)
+	c.r.Lines = append(c.r.Lines, pac.Lines...)
+	for _, warning := range pac.Warnings {
+		c.r.warn("Profile %q: %v", p.Name, warning)
+	}
+}
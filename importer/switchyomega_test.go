@@ -0,0 +1,114 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSwitchyOmega(t *testing.T) {
+	tests := []struct {
+		name     string
+		backup   string
+		lines    []string
+		warnings []string
+	}{
+		{
+			"switch profile",
+			`{"-startupProfileName": "auto switch",
+			"+proxy": {"name": "proxy", "profileType": "FixedProfile", "fallbackProxy": {"scheme": "socks5", "host": "127.0.0.1", "port": 1080}},
+			"+web": {"name": "web", "profileType": "FixedProfile", "fallbackProxy": {"scheme": "http", "host": "10.0.0.1", "port": 3128}},
+			"+auto switch": {"name": "auto switch", "profileType": "SwitchProfile", "defaultProfileName": "proxy", "rules": [
+				{"condition": {"conditionType": "HostWildcardCondition", "pattern": "*.example.com"}, "profileName": "direct"},
+				{"condition": {"conditionType": "IpCondition", "ip": "10.0.0.0", "prefixLength": 8}, "profileName": "direct"},
+				{"condition": {"conditionType": "UrlRegexCondition", "pattern": "^http://x"}, "profileName": "proxy"},
+				{"condition": {"conditionType": "HostWildcardCondition", "pattern": "*.corp"}, "profileName": "inner"},
+				{"condition": {"conditionType": "HostWildcardCondition", "pattern": "*.web"}, "profileName": "web"}]},
+			"+inner": {"name": "inner", "profileType": "SwitchProfile", "defaultProfileName": "direct", "rules": [
+				{"condition": {"conditionType": "HostWildcardCondition", "pattern": "secret.corp"}, "profileName": "proxy"}]}}`,
+			[]string{
+				"@target web http://10.0.0.1:3128",
+				"@target proxy socks5://127.0.0.1:1080",
+				"",
+				`# SwitchyOmega profile "auto switch"`,
+				".example.com direct",
+				"10.0.0.0/8 direct",
+				".corp jump inner",
+				".web web",
+				"* proxy",
+				"0.0.0.0/0 proxy",
+				"::/0 proxy",
+				"",
+				"@table inner",
+				"secret.corp proxy",
+			},
+			[]string{"can't translate UrlRegexCondition"},
+		},
+		{
+			"fixed profile",
+			`{"-startupProfileName": "proxy",
+			"+proxy": {"name": "proxy", "profileType": "FixedProfile", "fallbackProxy": {"scheme": "https", "host": "proxy.example.com", "port": 443}, "bypassList": [
+				{"conditionType": "BypassCondition", "pattern": "<local>"},
+				{"conditionType": "BypassCondition", "pattern": "127.0.0.1"},
+				{"conditionType": "BypassCondition", "pattern": "192.168.0.0/16"},
+				{"conditionType": "BypassCondition", "pattern": "*.lan"}]}}`,
+			[]string{
+				"@target proxy https://proxy.example.com:443",
+				"",
+				`# SwitchyOmega profile "proxy"`,
+				"127.0.0.1 direct",
+				"192.168.0.0/16 direct",
+				".lan direct",
+				"* proxy",
+				"0.0.0.0/0 proxy",
+				"::/0 proxy",
+			},
+			[]string{"<local>"},
+		},
+		{
+			"PAC profile",
+			`{"-startupProfileName": "pac",
+			"+pac": {"name": "pac", "profileType": "PacProfile", "pacScript": "function FindProxyForURL(url, host) { if (dnsDomainIs(host, \"example.com\")) return \"SOCKS5 127.0.0.1:1080\"; return \"DIRECT\"; }"}}`,
+			[]string{
+				"@target 127.0.0.1-1080 socks5://127.0.0.1:1080",
+				"",
+				`# SwitchyOmega profile "pac"`,
+				"# PAC file",
+				".example.com 127.0.0.1-1080",
+				"* direct",
+				"0.0.0.0/0 direct",
+				"::/0 direct",
+			},
+			nil,
+		},
+		{
+			"only switch profile",
+			`{"+switch": {"name": "switch", "profileType": "SwitchProfile", "defaultProfileName": "system", "rules": [
+				{"condition": {"conditionType": "HostWildcardCondition", "pattern": "*.example.com"}, "profileName": "socks4"}]},
+			"+socks4": {"name": "socks4", "profileType": "FixedProfile", "fallbackProxy": {"scheme": "socks4", "host": "127.0.0.1", "port": 1080}}}`,
+			[]string{
+				`# SwitchyOmega profile "switch"`,
+				"* direct",
+				"0.0.0.0/0 direct",
+				"::/0 direct",
+			},
+			[]string{"socks4 proxies not supported", "skipped rule .example.com", "System proxy settings not supported"},
+		},
+	}
+	for _, test := range tests {
+		r, err := SwitchyOmega(strings.NewReader(test.backup))
+		checkLines(t, test.name, convertedLines(t, r, err), test.lines)
+		checkWarnings(t, test.name, r.Warnings, test.warnings)
+	}
+}
+
+func TestSwitchyOmegaInvalid(t *testing.T) {
+	for _, backup := range []string{
+		`[1, 2]`,
+		`{"+a": {"name": "a", "profileType": "SwitchProfile"}, "+b": {"name": "b", "profileType": "SwitchProfile"}}`,
+		`{"+a": []}`,
+	} {
+		if _, err := SwitchyOmega(strings.NewReader(backup)); nil == err {
+			t.Errorf("no error for %v", backup)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/rus-cert/socks-router/routing"
+)
+
+// shared by the service (StateDirectory= in socks-router.service) and
+// the learned command, so both need to use the same default
+const defaultStateDir = "/var/lib/socks-router"
+
+// routes learned by "auto:" targets
+func learnedFile() string {
+	return filepath.Join(stateDir, "learned")
+}
+
+// learned [forget ADDRESS...|forget-all]: show or remove learned routes
+func learnedCommand(args []string) error {
+	if nil == routing.Learned {
+		return fmt.Errorf("Couldn't load learned routes from %q", learnedFile())
+	}
+	if 0 == len(args) {
+		for _, route := range routing.Learned.List() {
+			fmt.Println(route)
+		}
+		return nil
+	}
+	switch args[0] {
+	case "forget":
+		if 1 == len(args) {
+			return fmt.Errorf("Usage: learned forget ADDRESS...")
+		}
+		for _, address := range args[1:] {
+			if n, err := routing.Learned.Forget(address); nil != err {
+				return err
+			} else if 0 == n {
+				return fmt.Errorf("No learned route for %q", address)
+			}
+		}
+		return nil
+	case "forget-all":
+		return routing.Learned.ForgetAll()
+	default:
+		return fmt.Errorf("Usage: learned [forget ADDRESS...|forget-all]")
+	}
+}
@@ -3,13 +3,18 @@ package main
 import (
 	"flag"
 	"net"
+	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 
 	"github.com/rus-cert/socks-router/log"
 	"github.com/rus-cert/socks-router/routing"
+	"github.com/rus-cert/socks-router/sniff"
 )
 
 type stringList struct {
@@ -34,14 +39,44 @@ func (i *stringList) Get() []string {
 }
 
 var configFile string
+var runtimeDir string
+var stateDir string
+var learnedExpiry time.Duration
 var listenAddrsVar = stringList{nil, []string{"127.0.0.1:8000", "[::1]:8000"}}
 var debugFlag bool
+var sniffFlag bool
+var geoipFiles = stringList{nil, nil}
+var geoipResolveFlag bool
+var sniffTimeout time.Duration
+var sniffPorts = stringList{nil, sniff.DefaultPorts}
 
 func init() {
 	defConfig, _ := homedir.Expand("~/.socks-routes")
 	flag.BoolVar(&debugFlag, "debug", false, "Enable debug logging")
 	flag.StringVar(&configFile, "config", defConfig, "Path to configfile")
+	flag.StringVar(&runtimeDir, "runtime-dir", defaultRuntimeDir, "Directory for runtime state (active profile)")
+	flag.StringVar(&stateDir, "state-dir", defaultStateDir, "Directory for persistent state (learned routes, subscription cache)")
+	flag.DurationVar(&learnedExpiry, "learned-expiry", 24*time.Hour, "How long auto: targets remember destinations only reachable through the proxy")
 	flag.Var(&listenAddrsVar, "listen", "TCP Address to bind proxy to; can be passed multiple times")
+	flag.BoolVar(&sniffFlag, "sniff", false, "Route requests for IP addresses by hostname found in client data (TLS SNI, HTTP Host)")
+	flag.Var(&geoipFiles, "mmdb", "MaxMind DB file (GeoIP2/GeoLite2 country or ASN) for geo: and asn: matches; can be passed multiple times")
+	flag.BoolVar(&geoipResolveFlag, "geoip-resolve", false, "Resolve hostnames for geo: and asn: matches")
+	flag.DurationVar(&sniffTimeout, "sniff-timeout", time.Second, "How long to wait for client data when sniffing")
+	flag.Var(&sniffPorts, "sniff-port", "Destination port to sniff; can be passed multiple times")
+}
+
+// dump route usage on SIGUSR1
+func logStatsOnSignal(routingMap *routing.Map) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	go func() {
+		for range c {
+			log.Info.Println("route statistics:")
+			for _, stats := range routingMap.Stats() {
+				log.Info.Printf("  %v", stats)
+			}
+		}
+	}()
 }
 
 func main() {
@@ -51,20 +86,54 @@ func main() {
 	}
 	listenAddrs := listenAddrsVar.Get()
 
+	if files := geoipFiles.Get(); 0 != len(files) {
+		if db, err := routing.OpenGeoIPDatabase(files...); nil != err {
+			log.Error.Fatalf("Couldn't load GeoIP database: %v", err)
+		} else {
+			db.Resolve = geoipResolveFlag
+			routing.GeoIP = db
+		}
+	}
+
+	if learned, err := routing.OpenLearnedRoutes(learnedFile(), learnedExpiry, 0 == flag.NArg()); nil != err {
+		log.Error.Printf("Couldn't load learned routes: %v", err)
+	} else {
+		routing.Learned = learned
+	}
+
+	routing.SubscriptionCacheDir = stateDir
+
+	if 0 != flag.NArg() {
+		runCommand(flag.Args())
+		return
+	}
+
 	if routingMap, err := routing.ReadMapFile(configFile); nil != err {
 		log.Error.Fatalf("Couldn't read config file: %v", err)
 	} else {
 		log.Info.Println("socks router starting")
+		routingMap.Start()
+		logStatsOnSignal(routingMap)
+		watchProfile(routingMap)
 
 		pm := ProtocolMultiplexer{}
 
-		if socksHandler, err := CreateSocksHandler(routingMap); nil != err {
+		var sniffer *sniff.Sniffer
+		if sniffFlag {
+			sniffer = &sniff.Sniffer{
+				Dialer:  routingMap,
+				Timeout: sniffTimeout,
+				Ports:   sniffPorts.Get(),
+			}
+		}
+
+		if socksHandler, err := CreateSocksHandler(routingMap, sniffer); nil != err {
 			log.Error.Fatal(err)
 		} else {
 			pm.Handlers = append(pm.Handlers, socksHandler)
 		}
 
-		if httpHandler, err := CreateHTTPHandler(routingMap); nil != err {
+		if httpHandler, err := CreateHTTPHandler(routingMap, sniffer); nil != err {
 			log.Error.Fatal(err)
 		} else {
 			pm.Handlers = append(pm.Handlers, httpHandler)
@@ -12,7 +12,7 @@ import (
 )
 
 // Dialer is imported from routing/
-type Dialer routing.Dialer
+type Dialer routing.ContextDialer
 
 // ConnHandler describe anything capable of handling connections
 type ConnHandler interface {
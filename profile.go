@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rus-cert/socks-router/log"
+	"github.com/rus-cert/socks-router/routing"
+)
+
+const profileCheckInterval = time.Second
+
+// shared by the service (RuntimeDirectory= in socks-router.service) and
+// the profile command, so both need to use the same default
+const defaultRuntimeDir = "/run/socks-router"
+
+// contains the name of the active profile
+func profileFile() string {
+	return filepath.Join(runtimeDir, "profile")
+}
+
+func readProfileFile() (string, error) {
+	if data, err := ioutil.ReadFile(profileFile()); nil != err {
+		return "", err
+	} else {
+		return strings.TrimSpace(string(data)), nil
+	}
+}
+
+func writeProfileFile(name string) error {
+	if err := os.MkdirAll(runtimeDir, 0755); nil != err {
+		return err
+	}
+	return ioutil.WriteFile(profileFile(), []byte(name+"\n"), 0644)
+}
+
+func loadProfileFile(routingMap *routing.Map) {
+	if name, err := readProfileFile(); nil != err {
+		if !os.IsNotExist(err) {
+			log.Error.Printf("Couldn't read profile: %v", err)
+		}
+	} else if name != routingMap.ActiveProfile() {
+		if err := routingMap.SetProfile(name); nil != err {
+			log.Error.Printf("Couldn't switch profile: %v", err)
+		} else {
+			log.Info.Printf("switched to profile %q", name)
+		}
+	}
+}
+
+// switch profiles through the profile file in the runtime directory
+// and with SIGUSR2 (next profile)
+func watchProfile(routingMap *routing.Map) {
+	if 0 == len(routingMap.Profiles()) {
+		return
+	}
+	loadProfileFile(routingMap)
+	log.Info.Printf("active profile: %q", routingMap.ActiveProfile())
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR2)
+	go func() {
+		var lastModTime time.Time
+		ticker := time.NewTicker(profileCheckInterval)
+		for {
+			select {
+			case <-c:
+				if name, err := routingMap.NextProfile(); nil != err {
+					log.Error.Printf("Couldn't switch profile: %v", err)
+				} else {
+					log.Info.Printf("switched to profile %q", name)
+					if err := writeProfileFile(name); nil != err {
+						log.Debug.Printf("Couldn't store profile: %v", err)
+					}
+				}
+			case <-ticker.C:
+				if fi, err := os.Stat(profileFile()); nil == err && !fi.ModTime().Equal(lastModTime) {
+					lastModTime = fi.ModTime()
+					loadProfileFile(routingMap)
+				}
+			}
+		}
+	}()
+}
+
+// profile [NAME]: show profiles or switch the active profile
+func profileCommand(args []string) error {
+	routingMap, err := routing.ReadMapFile(configFile)
+	if nil != err {
+		return err
+	}
+	if 0 == len(routingMap.Profiles()) {
+		return fmt.Errorf("No profiles defined in %q", configFile)
+	}
+	switch len(args) {
+	case 0:
+		routingMap.CheckConditions()
+		if name, err := readProfileFile(); nil == err {
+			routingMap.SetProfile(name)
+		}
+		for _, name := range routingMap.Profiles() {
+			if name == routingMap.ActiveProfile() {
+				fmt.Printf("* %v\n", name)
+			} else {
+				fmt.Printf("  %v\n", name)
+			}
+		}
+		return nil
+	case 1:
+		if err := routingMap.SetProfile(args[0]); nil != err {
+			return err
+		}
+		return writeProfileFile(args[0])
+	default:
+		return fmt.Errorf("Usage: profile [NAME]")
+	}
+}
@@ -4,8 +4,42 @@ import (
 	"fmt"
 	"net"
 	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// lowercase, map unicode to punycode; rejects invalid labels and names
+// exceeding the DNS length limits.  STD3 rules are checked separately
+// (see isHostnameChar): intranet names (and SRV style names) contain
+// underscores.
+var hostnameProfile = idna.New(
+	idna.MapForLookup(),
+	idna.StrictDomainName(false),
+	idna.BidiRule(),
+	idna.VerifyDNSLength(true),
+	idna.Transitional(false),
 )
 
+// NormalizeHostname returns the canonical form of a hostname used for
+// matching: lowercase, punycode (IDNA) and without trailing dot
+func NormalizeHostname(host string) (string, error) {
+	if ascii, err := hostnameProfile.ToASCII(removeTrailingDot(host)); nil != err {
+		return "", fmt.Errorf("Invalid hostname %q: %v", host, err)
+	} else {
+		for i := 0; i < len(ascii); i++ {
+			if !isHostnameChar(ascii[i]) {
+				return "", fmt.Errorf("Invalid hostname %q: invalid character %q", host, ascii[i])
+			}
+		}
+		return ascii, nil
+	}
+}
+
+// STD3 (letters, digits, hyphen) plus underscore
+func isHostnameChar(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || '-' == c || '_' == c || '.' == c
+}
+
 func ParseAddress(addr string) (*AddressDetails, error) {
 	if host, port, err := net.SplitHostPort(addr); nil != err {
 		return nil, err
@@ -13,6 +47,9 @@ func ParseAddress(addr string) (*AddressDetails, error) {
 		zoneParts := strings.Split(host, "%")
 		var zone string
 		if 2 == len(zoneParts) {
+			if 0 == len(zoneParts[1]) {
+				return nil, fmt.Errorf("Empty zone in %q", host)
+			}
 			host = zoneParts[0]
 			zone = zoneParts[1]
 		} else if 1 != len(zoneParts) {
@@ -21,6 +58,10 @@ func ParseAddress(addr string) (*AddressDetails, error) {
 		ip := net.ParseIP(host)
 		if ip != nil {
 			host = ""
+		} else if host, err = NormalizeHostname(host); nil != err {
+			return nil, err
+		} else {
+			addr = net.JoinHostPort(host, port)
 		}
 		return &AddressDetails{
 			Address: addr,
@@ -33,9 +74,22 @@ func ParseAddress(addr string) (*AddressDetails, error) {
 }
 
 type AddressDetails struct {
-	Address string // for dial functions: [...]:...
-	FQDN    string // only the domain name, if available
+	Address string // for dial functions: [...]:...; normalized hostname
+	FQDN    string // only the domain name (normalized), if available
 	IP      net.IP
 	Zone    string // ipv6 zone [...%zone]:...
 	Port    string
+	// names the CNAME chain of FQDN leads to (see @follow-cname)
+	Aliases []string
+}
+
+// replaces the host of the address by an IP address
+func (a *AddressDetails) setIP(ip net.IP, zone string) {
+	a.IP = ip
+	a.Zone = zone
+	host := ip.String()
+	if 0 != len(zone) {
+		host += "%" + zone
+	}
+	a.Address = net.JoinHostPort(host, a.Port)
 }
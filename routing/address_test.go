@@ -0,0 +1,67 @@
+package routing
+
+import (
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		address string
+		fqdn    string
+		ip      string
+		zone    string
+		port    string
+	}{
+		{"example.com:80", "example.com:80", "example.com", "", "", "80"},
+		{"Example.COM.:443", "example.com:443", "example.com", "", "", "443"},
+		{"bücher.example:80", "xn--bcher-kva.example:80", "xn--bcher-kva.example", "", "", "80"},
+		{"my_host.example.com:80", "my_host.example.com:80", "my_host.example.com", "", "", "80"},
+		{"_ldap._tcp.Example.com:389", "_ldap._tcp.example.com:389", "_ldap._tcp.example.com", "", "", "389"},
+		{"192.0.2.1:22", "192.0.2.1:22", "", "192.0.2.1", "", "22"},
+		{"[2001:db8::1]:443", "[2001:db8::1]:443", "", "2001:db8::1", "", "443"},
+		{"[fe80::1%eth0]:80", "[fe80::1%eth0]:80", "", "fe80::1", "eth0", "80"},
+	}
+	for _, test := range tests {
+		ad, err := ParseAddress(test.addr)
+		if nil != err {
+			t.Errorf("ParseAddress(%q): %v", test.addr, err)
+			continue
+		}
+		ip := ""
+		if nil != ad.IP {
+			ip = ad.IP.String()
+		}
+		if ad.Address != test.address || ad.FQDN != test.fqdn || ip != test.ip || ad.Zone != test.zone || ad.Port != test.port {
+			t.Errorf("ParseAddress(%q) = %+v", test.addr, ad)
+		}
+	}
+}
+
+func TestParseAddressInvalid(t *testing.T) {
+	for _, addr := range []string{
+		"example.com",
+		"[fe80::1%a%b]:80",
+		"a..b:80",
+		"xn--a.example:80",
+		"foo bar.com:80",
+		"a/b.example:80",
+		"evil@host:80",
+		"nul\x00.example:80",
+		"-leading.example:80",
+		"[fe80::1%]:80",
+	} {
+		if ad, err := ParseAddress(addr); nil == err {
+			t.Errorf("ParseAddress(%q) = %+v, expected error", addr, ad)
+		}
+	}
+}
+
+func TestNormalizeHostnameRules(t *testing.T) {
+	// config rules with underscores need to load
+	for _, rule := range []string{"my_host.example.com direct", "._tcp.example.com direct"} {
+		if _, err := ParseMatch(rule[:len(rule)-7]); nil != err {
+			t.Errorf("ParseMatch(%q): %v", rule, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/rus-cert/socks-router/log"
+)
+
+// how long "auto:" targets wait for direct connections
+const autoDirectTimeout = 3 * time.Second
+
+// auto:PROXY
+func (p *mapParser) parseAutoTarget(name string) (*Target, error) {
+	if nil == Learned {
+		return nil, fmt.Errorf("auto: targets require learned routes")
+	} else if proxy, err := p.parseTarget(name[5:]); nil != err {
+		return nil, err
+	} else if nil == proxy.Dialer {
+		return nil, fmt.Errorf("Invalid proxy for auto target: %q", name)
+	} else {
+		return &Target{
+			Name:   name,
+			Dialer: autoDialer{proxy: proxy},
+		}, nil
+	}
+}
+
+// connects directly unless that times out or is refused; then uses the
+// proxy and remembers the destination (see Learned)
+type autoDialer struct {
+	proxy *Target
+}
+
+func (a autoDialer) Dial(network, address string) (net.Conn, error) {
+	if route, ok := Learned.lookup(address); ok && route.Via == a.proxy.Name {
+		log.Debug.Printf("Using learned route to %v over %v", address, route.Via)
+		return a.proxy.Dialer.Dial(network, address)
+	}
+	direct := net.Dialer{
+		Timeout:   autoDirectTimeout,
+		DualStack: true,
+	}
+	conn, err := direct.Dial(network, address)
+	if nil == err || !isUnreachable(err) {
+		return conn, err
+	}
+	log.Access.Printf("direct connection to %v failed (%v), trying %v", address, err, a.proxy.Name)
+	if conn, err := a.proxy.Dialer.Dial(network, address); nil != err {
+		return nil, err
+	} else {
+		if err := Learned.learn(address, a.proxy.Name); nil != err {
+			log.Error.Printf("Couldn't store learned route to %v: %v", address, err)
+		}
+		return conn, nil
+	}
+}
+
+// timeouts, refused connections and unreachable networks / hosts
+func isUnreachable(err error) bool {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		if sysErr, ok := opErr.Err.(*os.SyscallError); ok {
+			switch sysErr.Err {
+			case syscall.ECONNREFUSED, syscall.ENETUNREACH, syscall.EHOSTUNREACH:
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package routing
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestIsUnreachable(t *testing.T) {
+	dialErr := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errno)}
+	}
+	tests := []struct {
+		err         error
+		unreachable bool
+	}{
+		{dialErr(syscall.ECONNREFUSED), true},
+		{dialErr(syscall.ENETUNREACH), true},
+		{dialErr(syscall.EHOSTUNREACH), true},
+		{dialErr(syscall.ECONNRESET), false},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "timeout", IsTimeout: true}}, true},
+		{errors.New("rejected"), false},
+	}
+	for _, test := range tests {
+		if unreachable := isUnreachable(test.err); unreachable != test.unreachable {
+			t.Errorf("%v: got %v, want %v", test.err, unreachable, test.unreachable)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// ProcessInfo describes the local process owning a client connection
+type ProcessInfo struct {
+	PID int // 0 if the process wasn't found (but the UID was)
+	UID int
+	Exe string // empty if unknown
+}
+
+// Client is the connection a request was received on; details about it
+// are looked up once and cached
+type Client struct {
+	Conn net.Conn
+
+	processOnce sync.Once
+	process     *ProcessInfo
+	processErr  error
+}
+
+// Process returns the local process on the other end of the (loopback)
+// client connection
+func (c *Client) Process() (*ProcessInfo, error) {
+	c.processOnce.Do(func() {
+		local, lok := c.Conn.LocalAddr().(*net.TCPAddr)
+		remote, rok := c.Conn.RemoteAddr().(*net.TCPAddr)
+		if !lok || !rok {
+			c.processErr = fmt.Errorf("Not a TCP connection")
+		} else if !remote.IP.IsLoopback() {
+			c.processErr = fmt.Errorf("Not a local connection")
+		} else {
+			c.process, c.processErr = lookupProcess(remote, local)
+		}
+	})
+	return c.process, c.processErr
+}
+
+// key is an unexported type for keys defined in this package. This
+// prevents collisions with keys defined in other packages.
+type clientKey int
+
+// clientContextKey is the key for client values in Contexts.  It is
+// unexported; clients use NewClientContext and ClientFromContext instead
+// of using this key directly.
+const clientContextKey clientKey = 0
+
+// NewClientContext returns a new Context that carries the client
+// connection.
+func NewClientContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, clientContextKey, &Client{Conn: conn})
+}
+
+// ClientFromContext returns the Client stored in ctx, if any.
+func ClientFromContext(ctx context.Context) (*Client, bool) {
+	if val := ctx.Value(clientContextKey); nil == val {
+		return nil, false
+	} else if client, ok := val.(*Client); ok && nil != client {
+		return client, true
+	} else {
+		return nil, false
+	}
+}
+
+func processFromContext(ctx context.Context) *ProcessInfo {
+	if client, ok := ClientFromContext(ctx); !ok {
+		return nil
+	} else if process, err := client.Process(); nil != err {
+		return nil
+	} else {
+		return process
+	}
+}
+
+// "uid:1000" or "uid:username": local client process user
+type uidMatch struct {
+	UID int
+}
+
+func parseUIDMatch(uid string) (Matcher, error) {
+	if n, err := strconv.Atoi(uid); nil == err && n >= 0 {
+		return uidMatch{UID: n}, nil
+	} else if u, err := user.Lookup(uid); nil != err {
+		return nil, fmt.Errorf("Invalid user %q: %v", uid, err)
+	} else if n, err := strconv.Atoi(u.Uid); nil != err {
+		return nil, fmt.Errorf("Invalid user %q: non-numeric uid %q", uid, u.Uid)
+	} else {
+		return uidMatch{UID: n}, nil
+	}
+}
+
+func (m uidMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	process := processFromContext(ctx)
+	return nil != process && process.UID == m.UID
+}
+
+func (m uidMatch) String() string {
+	return fmt.Sprintf("uid:%v", m.UID)
+}
+
+// "exe:/usr/bin/firefox": local client process executable
+type exeMatch struct {
+	Exe string
+	// symlinks resolved (like the executable path of a process); Exe if
+	// it doesn't exist (yet)
+	Path string
+}
+
+func parseExeMatch(exe string) (Matcher, error) {
+	if !filepath.IsAbs(exe) {
+		return nil, fmt.Errorf("Executable path %q not absolute", exe)
+	}
+	m := exeMatch{Exe: filepath.Clean(exe)}
+	if path, err := filepath.EvalSymlinks(m.Exe); nil != err {
+		m.Path = m.Exe
+	} else {
+		m.Path = path
+	}
+	return m, nil
+}
+
+func (m exeMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	process := processFromContext(ctx)
+	return nil != process && process.Exe == m.Path
+}
+
+func (m exeMatch) String() string {
+	return "exe:" + m.Exe
+}
+
+// "client:CIDR": client IP address
+type clientMatch struct {
+	CIDR net.IPNet
+}
+
+func parseClientMatch(network string) (Matcher, error) {
+	if n, err := parseIPNet(network); nil != err {
+		return nil, err
+	} else {
+		return clientMatch{CIDR: n}, nil
+	}
+}
+
+func (m clientMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	if client, ok := ClientFromContext(ctx); !ok {
+		return false
+	} else if addr, ok := client.Conn.RemoteAddr().(*net.TCPAddr); !ok {
+		return false
+	} else {
+		return containsIP(m.CIDR, addr.IP)
+	}
+}
+
+func (m clientMatch) String() string {
+	return "client:" + m.CIDR.String()
+}
@@ -0,0 +1,62 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestProcessMatch(t *testing.T) {
+	if "linux" != runtime.GOOS {
+		t.Skip("process lookup only supported on Linux")
+	}
+	exe, err := os.Executable()
+	if nil != err {
+		t.Fatal(err)
+	}
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(exe, link); nil != err {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := l.Accept()
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := NewClientContext(context.Background(), conn)
+
+	tests := []struct {
+		pattern string
+		match   bool
+	}{
+		{"exe:" + exe, true},
+		// symlinks are resolved
+		{"exe:" + link, true},
+		{"exe:/nonexistent/" + filepath.Base(exe), false},
+		{"uid:" + fmt.Sprint(os.Getuid()), true},
+		{"uid:" + fmt.Sprint(os.Getuid()+1), false},
+	}
+	for _, test := range tests {
+		if m, err := newMapParser().parsePattern(test.pattern); nil != err {
+			t.Errorf("%v: %v", test.pattern, err)
+		} else if match := m.Match(ctx, "tcp", AddressDetails{}); match != test.match {
+			t.Errorf("%v: got %v, want %v", test.pattern, match, test.match)
+		}
+	}
+}
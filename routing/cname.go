@@ -0,0 +1,53 @@
+package routing
+
+import (
+	"fmt"
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	"github.com/rus-cert/socks-router/log"
+)
+
+const defaultCNAMEDepth = 8
+
+// @follow-cname [MAXDEPTH]: domain matches also apply to the names the
+// CNAME chain of a requested hostname leads to
+func (p *mapParser) parseFollowCNAMEDirective(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Usage: @follow-cname [MAXDEPTH]")
+	}
+	depth := defaultCNAMEDepth
+	if 1 == len(args) {
+		if n, err := strconv.Atoi(args[0]); nil != err || n < 0 {
+			return fmt.Errorf("Invalid CNAME depth %q", args[0])
+		} else {
+			depth = n
+		}
+	}
+	if _, err := getLocalResolver(); nil != err {
+		return fmt.Errorf("Following CNAMEs needs local resolver: %v", err)
+	}
+	p.m.cnameDepth = depth
+	return nil
+}
+
+// add the CNAME chain of the hostname (up to the configured depth)
+func (m Map) addAliases(ctx context.Context, address *AddressDetails) {
+	if 0 == m.cnameDepth || 0 == len(address.FQDN) || 0 != len(address.Aliases) {
+		return
+	}
+	resolver, _ := getLocalResolver()
+	if names, err := resolver.CNAMEs(ctx, address.FQDN); nil != err {
+		log.Debug.Printf("Couldn't follow CNAMEs of %v: %v", address.FQDN, err)
+	} else {
+		if len(names) > m.cnameDepth {
+			names = names[:m.cnameDepth]
+		}
+		for _, name := range names {
+			if name, err := NormalizeHostname(name); nil == err {
+				address.Aliases = append(address.Aliases, name)
+			}
+		}
+	}
+}
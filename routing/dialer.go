@@ -2,9 +2,17 @@ package routing
 
 import (
 	"net"
+
+	"golang.org/x/net/context"
 )
 
 type Dialer interface {
 	// Dial connects to the given address via the proxy.
 	Dial(network, addr string) (c net.Conn, err error)
 }
+
+type ContextDialer interface {
+	// DialContext connects to the given address via the proxy; the
+	// context carries request details (see NewClientContext)
+	DialContext(ctx context.Context, network, addr string) (c net.Conn, err error)
+}
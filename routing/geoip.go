@@ -0,0 +1,262 @@
+package routing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+	"golang.org/x/net/context"
+
+	"github.com/rus-cert/socks-router/log"
+)
+
+// GeoIP is used by "geo:" and "asn:" matches; needs to be set before
+// reading a Map using those
+var GeoIP *GeoIPDatabase
+
+const geoipReloadInterval = 30 * time.Second
+const geoipResolveCacheTime = time.Minute
+const geoipResolveTimeout = 2 * time.Second
+
+// fields from GeoLite2/GeoIP2 Country, City and ASN databases
+type geoipRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	ASN uint `maxminddb:"autonomous_system_number"`
+}
+
+type geoipFile struct {
+	filename string
+	modTime  time.Time
+	size     int64
+	reader   *maxminddb.Reader
+}
+
+type resolvedAddresses struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// GeoIPDatabase looks up countries and AS numbers in (one or more)
+// MaxMind DB files; changed files are reloaded
+type GeoIPDatabase struct {
+	// resolve hostnames to match them by their IP addresses
+	Resolve bool
+
+	mutex    sync.Mutex
+	files    []*geoipFile
+	resolved map[string]resolvedAddresses
+}
+
+// OpenGeoIPDatabase loads the given files and checks them regularly for
+// changes
+func OpenGeoIPDatabase(filenames ...string) (*GeoIPDatabase, error) {
+	db := &GeoIPDatabase{
+		resolved: make(map[string]resolvedAddresses),
+	}
+	for _, filename := range filenames {
+		f := &geoipFile{filename: filename}
+		if err := f.load(); nil != err {
+			return nil, err
+		}
+		db.files = append(db.files, f)
+	}
+	go db.watch()
+	return db, nil
+}
+
+func (f *geoipFile) changed() (bool, error) {
+	if fi, err := os.Stat(f.filename); nil != err {
+		return false, err
+	} else {
+		return !fi.ModTime().Equal(f.modTime) || fi.Size() != f.size, nil
+	}
+}
+
+func (f *geoipFile) load() error {
+	fi, err := os.Stat(f.filename)
+	if nil != err {
+		return err
+	}
+	// not using mmap: old readers might still be in use after a reload
+	if data, err := ioutil.ReadFile(f.filename); nil != err {
+		return err
+	} else if reader, err := maxminddb.FromBytes(data); nil != err {
+		return fmt.Errorf("Invalid MaxMind DB file %q: %v", f.filename, err)
+	} else {
+		f.reader = reader
+		f.modTime = fi.ModTime()
+		f.size = fi.Size()
+		return nil
+	}
+}
+
+// files are replaced, never modified: Lookup uses them without the lock
+func (db *GeoIPDatabase) watch() {
+	for range time.Tick(geoipReloadInterval) {
+		db.mutex.Lock()
+		files := db.files
+		db.mutex.Unlock()
+
+		var updated []*geoipFile
+		reloaded := false
+		for _, f := range files {
+			if changed, err := f.changed(); nil != err {
+				log.Error.Printf("Couldn't check GeoIP database: %v", err)
+			} else if changed {
+				reload := &geoipFile{filename: f.filename}
+				if err := reload.load(); nil != err {
+					log.Error.Printf("Couldn't reload GeoIP database: %v", err)
+				} else {
+					log.Info.Printf("Reloaded GeoIP database %q", f.filename)
+					f = reload
+					reloaded = true
+				}
+			}
+			updated = append(updated, f)
+		}
+		if reloaded {
+			db.mutex.Lock()
+			db.files = updated
+			db.mutex.Unlock()
+		}
+	}
+}
+
+// Lookup returns the country ISO code and AS number of an IP address
+// (empty / 0 if not known)
+func (db *GeoIPDatabase) Lookup(ip net.IP) (country string, asn uint) {
+	db.mutex.Lock()
+	files := db.files
+	db.mutex.Unlock()
+
+	for _, f := range files {
+		var record geoipRecord
+		if err := f.reader.Lookup(ip, &record); nil != err {
+			log.Debug.Printf("GeoIP lookup of %v in %q failed: %v", ip, f.filename, err)
+			continue
+		}
+		if 0 == len(country) {
+			country = record.Country.ISOCode
+		}
+		if 0 == asn {
+			asn = record.ASN
+		}
+	}
+	return
+}
+
+// IP addresses to look up for a request
+func (db *GeoIPDatabase) addresses(ctx context.Context, address AddressDetails) []net.IP {
+	if nil != address.IP {
+		return []net.IP{address.IP}
+	} else if !db.Resolve || 0 == len(address.FQDN) {
+		return nil
+	}
+
+	db.mutex.Lock()
+	cached, ok := db.resolved[address.FQDN]
+	db.mutex.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.ips
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, geoipResolveTimeout)
+	defer cancel()
+	var ips []net.IP
+	addrs, err := net.DefaultResolver.LookupIPAddr(lookupCtx, address.FQDN)
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	if nil != err {
+		log.Debug.Printf("Couldn't resolve %q for GeoIP matching: %v", address.FQDN, err)
+		if nil != lookupCtx.Err() {
+			// timed out or request gone: don't remember
+			return nil
+		}
+	}
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	for name, entry := range db.resolved {
+		if time.Now().After(entry.expires) {
+			delete(db.resolved, name)
+		}
+	}
+	db.resolved[address.FQDN] = resolvedAddresses{
+		ips:     ips,
+		expires: time.Now().Add(geoipResolveCacheTime),
+	}
+	return ips
+}
+
+// "geo:CC": country by ISO code
+type geoMatch struct {
+	Country string
+}
+
+func parseGeoMatch(country string) (Matcher, error) {
+	if nil == GeoIP {
+		return nil, fmt.Errorf("geo: matches require a GeoIP database")
+	} else if 2 != len(country) {
+		return nil, fmt.Errorf("Invalid country code %q", country)
+	}
+	return geoMatch{Country: strings.ToUpper(country)}, nil
+}
+
+func (m geoMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	if nil == GeoIP {
+		return false
+	}
+	for _, ip := range GeoIP.addresses(ctx, address) {
+		if country, _ := GeoIP.Lookup(ip); country == m.Country {
+			return true
+		}
+	}
+	return false
+}
+
+func (m geoMatch) String() string {
+	return "geo:" + m.Country
+}
+
+// "asn:AS13335" or "asn:13335"
+type asnMatch struct {
+	ASN uint
+}
+
+func parseASNMatch(asn string) (Matcher, error) {
+	if nil == GeoIP {
+		return nil, fmt.Errorf("asn: matches require a GeoIP database")
+	}
+	if strings.HasPrefix(strings.ToUpper(asn), "AS") {
+		asn = asn[2:]
+	}
+	if n, err := strconv.ParseUint(asn, 10, 32); nil != err || 0 == n {
+		return nil, fmt.Errorf("Invalid AS number %q", asn)
+	} else {
+		return asnMatch{ASN: uint(n)}, nil
+	}
+}
+
+func (m asnMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	if nil == GeoIP {
+		return false
+	}
+	for _, ip := range GeoIP.addresses(ctx, address) {
+		if _, asn := GeoIP.Lookup(ip); asn == m.ASN {
+			return true
+		}
+	}
+	return false
+}
+
+func (m asnMatch) String() string {
+	return fmt.Sprintf("asn:AS%v", m.ASN)
+}
@@ -0,0 +1,321 @@
+package routing
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/rus-cert/socks-router/log"
+)
+
+// requests helpers answer with "reject" fail
+var rejectTarget = Target{Name: "reject", Dialer: rejectDialer{}}
+
+type rejectDialer struct{}
+
+func (rejectDialer) Dial(network, address string) (net.Conn, error) {
+	return nil, fmt.Errorf("Connection to %v rejected", address)
+}
+
+const (
+	defaultHelperChildren  = 4
+	defaultHelperTimeout   = time.Second
+	defaultHelperCacheTime = time.Minute
+)
+
+// one JSON line per request on stdin of the helper
+type helperRequest struct {
+	Network  string   `json:"network"`
+	Address  string   `json:"address"`
+	FQDN     string   `json:"fqdn,omitempty"`
+	IP       string   `json:"ip,omitempty"`
+	Port     string   `json:"port"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Client   string   `json:"client,omitempty"`
+	Protocol string   `json:"protocol,omitempty"`
+}
+
+type helperAnswer struct {
+	target  *Target
+	expires time.Time
+}
+
+// helperPool runs up to Children instances of an external program which
+// answer each request line with a target name, "reject" or an empty
+// line (continue with the next route)
+type helperPool struct {
+	Name      string
+	Command   []string
+	Children  int
+	Timeout   time.Duration
+	CacheTime time.Duration
+	// used when the helper doesn't answer in time (nil: next route)
+	Fallback *Target
+	targets  *targetCache
+
+	// idle helpers; nil entries are started when needed
+	idle  chan *helperProcess
+	mutex sync.Mutex
+	cache map[string]helperAnswer
+}
+
+// @helper NAME [children=N] [timeout=DURATION] [cache=DURATION]
+// [fallback=TARGET] PROGRAM [ARGS...]
+func (p *mapParser) parseHelperDirective(args []string) error {
+	const usage = "Usage: @helper NAME [children=N] [timeout=DURATION] [cache=DURATION] [fallback=TARGET] PROGRAM [ARGS...]"
+	if len(args) < 2 {
+		return fmt.Errorf(usage)
+	}
+	name := args[0]
+	if _, ok := p.helpers[name]; ok {
+		return fmt.Errorf("Helper %q already defined", name)
+	}
+	pool := &helperPool{
+		Name:      name,
+		Children:  defaultHelperChildren,
+		Timeout:   defaultHelperTimeout,
+		CacheTime: defaultHelperCacheTime,
+		targets:   newTargetCache(p.m.Targets),
+		cache:     make(map[string]helperAnswer),
+	}
+	args = args[1:]
+	for ; 0 != len(args) && strings.Contains(args[0], "="); args = args[1:] {
+		kv := strings.SplitN(args[0], "=", 2)
+		var err error
+		switch kv[0] {
+		case "children":
+			if pool.Children, err = strconv.Atoi(kv[1]); nil == err && pool.Children < 1 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "timeout":
+			pool.Timeout, err = time.ParseDuration(kv[1])
+		case "cache":
+			pool.CacheTime, err = time.ParseDuration(kv[1])
+		case "fallback":
+			pool.Fallback, err = p.parseTarget(kv[1])
+		default:
+			return fmt.Errorf("Unknown helper option %q", kv[0])
+		}
+		if nil != err {
+			return fmt.Errorf("Invalid helper option %q: %v", args[0], err)
+		}
+	}
+	if 0 == len(args) {
+		return fmt.Errorf(usage)
+	}
+	pool.Command = args
+	pool.idle = make(chan *helperProcess, pool.Children)
+	for i := 0; i < pool.Children; i++ {
+		pool.idle <- nil
+	}
+	p.helpers[name] = pool
+	return nil
+}
+
+// "MATCH helper:NAME"
+type helperRoute struct {
+	Matcher Matcher
+	Pool    *helperPool
+	Options RouteOptions
+}
+
+func (p *mapParser) parseHelperRoute(matcher Matcher, name string, options RouteOptions) (Route, error) {
+	if pool, ok := p.helpers[name]; !ok {
+		return nil, fmt.Errorf("Unknown helper %q", name)
+	} else {
+		return helperRoute{Matcher: matcher, Pool: pool, Options: options}, nil
+	}
+}
+
+func (r helperRoute) Match(ctx context.Context, network string, address AddressDetails) *Target {
+	if !r.Matcher.Match(ctx, network, address) {
+		return nil
+	}
+	return r.Pool.decide(ctx, network, address)
+}
+
+func (r helperRoute) String() string {
+	return withOptions(fmt.Sprintf("%v helper:%v", r.Matcher, r.Pool.Name), r.Options)
+}
+
+func (r helperRoute) options() RouteOptions {
+	return r.Options
+}
+
+func (r helperRoute) specificity() specificity {
+	return specificityOf(r.Matcher)
+}
+
+func (pool *helperPool) decide(ctx context.Context, network string, address AddressDetails) *Target {
+	req := helperRequest{
+		Network: network,
+		Address: address.Address,
+		FQDN:    address.FQDN,
+		Port:    address.Port,
+		Aliases: address.Aliases,
+	}
+	if nil != address.IP {
+		req.IP = address.IP.String()
+	}
+	if c, ok := ClientFromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(c.Conn.RemoteAddr().String()); nil == err {
+			req.Client = host
+		}
+	}
+	req.Protocol, _ = ProtocolFromContext(ctx)
+	line, err := json.Marshal(req)
+	if nil != err {
+		log.Error.Printf("Helper %v: %v", pool.Name, err)
+		return pool.Fallback
+	}
+	key := string(line)
+
+	pool.mutex.Lock()
+	cached, ok := pool.cache[key]
+	pool.mutex.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.target
+	}
+
+	answer, err := pool.ask(line)
+	if nil != err {
+		log.Error.Printf("Helper %v failed for %v: %v", pool.Name, address.Address, err)
+		return pool.Fallback
+	}
+	var target *Target
+	switch answer {
+	case "":
+	case "reject":
+		target = &rejectTarget
+	default:
+		if target, err = pool.targets.target(answer); nil != err {
+			log.Error.Printf("Helper %v returned invalid target for %v: %v", pool.Name, address.Address, err)
+			return pool.Fallback
+		}
+	}
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	now := time.Now()
+	for k, a := range pool.cache {
+		if now.After(a.expires) {
+			delete(pool.cache, k)
+		}
+	}
+	pool.cache[key] = helperAnswer{target: target, expires: now.Add(pool.CacheTime)}
+	return target
+}
+
+// sends a request line to an idle helper (starting it if necessary) and
+// waits for the answer
+func (pool *helperPool) ask(line []byte) (string, error) {
+	timeout := time.NewTimer(pool.Timeout)
+	defer timeout.Stop()
+
+	var h *helperProcess
+	select {
+	case h = <-pool.idle:
+	case <-timeout.C:
+		return "", fmt.Errorf("No idle helper")
+	}
+	if nil != h && h.outOfSync() {
+		log.Error.Printf("Helper %v answered more than one line or exited; restarting", pool.Name)
+		h.kill()
+		h = nil
+	}
+	if nil == h {
+		var err error
+		if h, err = startHelper(pool.Command); nil != err {
+			pool.idle <- nil
+			return "", err
+		}
+	}
+
+	if _, err := h.stdin.Write(append(line, '\n')); nil != err {
+		h.kill()
+		pool.idle <- nil
+		return "", err
+	}
+	select {
+	case answer, ok := <-h.answers:
+		if !ok {
+			h.kill()
+			pool.idle <- nil
+			return "", fmt.Errorf("Helper exited")
+		}
+		pool.idle <- h
+		return strings.TrimSpace(answer), nil
+	case <-timeout.C:
+		// a late answer would be taken for the answer to the next request
+		h.kill()
+		pool.idle <- nil
+		return "", fmt.Errorf("Timeout")
+	}
+}
+
+type helperProcess struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	answers chan string
+}
+
+func startHelper(command []string) (*helperProcess, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if nil != err {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if nil != err {
+		return nil, err
+	}
+	if err := cmd.Start(); nil != err {
+		return nil, fmt.Errorf("Couldn't start helper %q: %v", command[0], err)
+	}
+	h := &helperProcess{
+		cmd:     cmd,
+		stdin:   stdin,
+		answers: make(chan string, 1),
+	}
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			h.answers <- scanner.Text()
+		}
+		close(h.answers)
+		cmd.Wait()
+	}()
+	return h, nil
+}
+
+// a line (or exit) since the last answer: answers to later requests
+// would be taken for the wrong ones
+func (h *helperProcess) outOfSync() bool {
+	select {
+	case <-h.answers:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *helperProcess) kill() {
+	h.stdin.Close()
+	h.cmd.Process.Kill()
+	// unblock the reader if it waits to deliver a late answer
+	go func() {
+		for range h.answers {
+		}
+	}()
+}
@@ -0,0 +1,124 @@
+package routing
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const testHelper = `#!/bin/sh
+echo $$ >> DIR/pids
+while read line; do
+  echo "$line" >> DIR/requests
+  case "$line" in
+    *'"fqdn":"vpn.example"'*) echo vpn;;
+    *'"fqdn":"reject.example"'*) echo reject;;
+    *'"fqdn":"invalid.example"'*) echo unknown-target;;
+    *'"fqdn":"slow.example"'*) sleep 2; echo vpn;;
+    *'"fqdn":"extra.example"'*) echo vpn; echo reject;;
+    *) echo;;
+  esac
+done
+`
+
+// lines of a file written by the helper
+func helperLog(t *testing.T, filename string) []string {
+	data, err := ioutil.ReadFile(filename)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// killed and exited (or not reaped yet)
+func processGone(pid string) bool {
+	stat, err := ioutil.ReadFile("/proc/" + pid + "/stat")
+	return os.IsNotExist(err) || strings.Contains(string(stat), ") Z ")
+}
+
+func TestHelperPool(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "helper.sh")
+	if err := ioutil.WriteFile(script, []byte(strings.Replace(testHelper, "DIR", dir, -1)), 0755); nil != err {
+		t.Fatal(err)
+	}
+	m, err := ReadMap(strings.NewReader(`@target vpn socks5://127.0.0.1:2080
+@helper policy children=1 timeout=300ms fallback=socks5://127.0.0.1:7 ` + script + `
+* helper:policy
+* socks5://127.0.0.1:8
+`))
+	if nil != err {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		address string
+		route   string
+	}{
+		{"vpn.example:443", "vpn"},
+		{"reject.example:443", "reject"},
+		// empty answer: next rule
+		{"other.example:443", "socks5://127.0.0.1:8"},
+		{"invalid.example:443", "socks5://127.0.0.1:7"},
+		// killed after the timeout
+		{"slow.example:443", "socks5://127.0.0.1:7"},
+		{"vpn.example:80", "vpn"},
+		// the extra line isn't taken for the answer to the next request
+		{"extra.example:443", "vpn"},
+		{"next.example:443", "socks5://127.0.0.1:8"},
+		// cached
+		{"vpn.example:443", "vpn"},
+		{"reject.example:443", "reject"},
+	}
+	for _, test := range tests {
+		if route := routeFor(m, test.address); route != test.route {
+			t.Errorf("%v: got %v, want %v", test.address, route, test.route)
+		}
+		if strings.HasPrefix(test.address, "extra.") {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	var asked int
+	for _, line := range helperLog(t, filepath.Join(dir, "requests")) {
+		if strings.Contains(line, `"address":"vpn.example:443"`) {
+			asked++
+		}
+	}
+	if 1 != asked {
+		t.Errorf("vpn.example:443 asked %v times", asked)
+	}
+	// started again after the timeout and after the extra line
+	pids := helperLog(t, filepath.Join(dir, "pids"))
+	if 3 != len(pids) {
+		t.Fatalf("%v helpers started, want 3", len(pids))
+	}
+	for _, pid := range pids[:2] {
+		for deadline := time.Now().Add(time.Second); !processGone(pid); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Errorf("helper %v still running", pid)
+				break
+			}
+		}
+	}
+}
+
+func TestHelperWithoutFallback(t *testing.T) {
+	m, err := ReadMap(strings.NewReader(`@helper missing /nonexistent/helper
+* helper:missing
+* socks5://127.0.0.1:8
+`))
+	if nil != err {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	ad, _ := ParseAddress("www.example.com:443")
+	// a helper failing to start continues with the next route
+	if d, _ := m.Decide(ctx, "tcp", *ad); "socks5://127.0.0.1:8" != d.TargetName() {
+		t.Errorf("got %v, want the next rule", d.TargetName())
+	}
+}
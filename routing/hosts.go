@@ -0,0 +1,125 @@
+package routing
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// address override for a hostname
+type hostEntry struct {
+	IP   net.IP
+	Zone string
+	// rewrite after routing (routes see the hostname only)
+	After bool
+}
+
+func parseHostIP(s string) (net.IP, string, error) {
+	ip, zone := s, ""
+	if percent := strings.IndexByte(s, '%'); -1 != percent {
+		ip, zone = s[:percent], s[percent+1:]
+	}
+	if parsed := net.ParseIP(ip); nil == parsed {
+		return nil, "", fmt.Errorf("Invalid IP address %q", s)
+	} else {
+		if ipv4 := parsed.To4(); nil != ipv4 {
+			parsed = ipv4
+		}
+		return parsed, zone, nil
+	}
+}
+
+// before (default) or after
+func parseHostOrder(args []string) (bool, error) {
+	if 0 == len(args) {
+		return false, nil
+	}
+	switch args[0] {
+	case "before":
+		return false, nil
+	case "after":
+		return true, nil
+	default:
+		return false, fmt.Errorf("Expected before or after, got %q", args[0])
+	}
+}
+
+func (p *mapParser) addHost(name string, ip net.IP, zone string, after bool) error {
+	if fqdn, err := NormalizeHostname(name); nil != err {
+		return fmt.Errorf("Invalid hostname %q: %v", name, err)
+	} else {
+		if nil == p.m.hosts {
+			p.m.hosts = make(map[string]hostEntry)
+		}
+		p.m.hosts[fqdn] = hostEntry{IP: ip, Zone: zone, After: after}
+		return nil
+	}
+}
+
+// @host NAME IP [before|after]
+func (p *mapParser) parseHostDirective(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("Usage: @host NAME IP [before|after]")
+	}
+	if ip, zone, err := parseHostIP(args[1]); nil != err {
+		return err
+	} else if after, err := parseHostOrder(args[2:]); nil != err {
+		return err
+	} else {
+		return p.addHost(args[0], ip, zone, after)
+	}
+}
+
+// @hosts FILE [before|after]: file in /etc/hosts format
+func (p *mapParser) parseHostsDirective(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("Usage: @hosts FILE [before|after]")
+	}
+	after, err := parseHostOrder(args[1:])
+	if nil != err {
+		return err
+	}
+	f, err := os.Open(args[0])
+	if nil != err {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	linenum := 0
+	for scanner.Scan() {
+		linenum += 1
+		fields := strings.Fields(strings.Split(scanner.Text(), "#")[0])
+		if 0 == len(fields) {
+			continue
+		} else if len(fields) < 2 {
+			return fmt.Errorf("Invalid line %v in %q", linenum, args[0])
+		}
+		ip, zone, err := parseHostIP(fields[0])
+		if nil != err {
+			return fmt.Errorf("Invalid line %v in %q: %v", linenum, args[0], err)
+		}
+		for _, name := range fields[1:] {
+			if err := p.addHost(name, ip, zone, after); nil != err {
+				return fmt.Errorf("Invalid line %v in %q: %v", linenum, args[0], err)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// replaces the hostname in requests for hostnames with an override; the
+// hostname is kept for routing (like sniffed hostnames).  Returns the
+// new IP address (nil if not rewritten).
+func (m Map) applyHosts(address *AddressDetails, after bool) net.IP {
+	if nil != address.IP || 0 == len(address.FQDN) {
+		return nil
+	}
+	entry, ok := m.hosts[address.FQDN]
+	if !ok || entry.After != after {
+		return nil
+	}
+	address.setIP(entry.IP, entry.Zone)
+	return entry.IP
+}
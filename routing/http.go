@@ -0,0 +1,156 @@
+package routing
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+type httpRequestKey int
+
+// httpRequestContextKey is the key for HTTP request values in Contexts.
+// It is unexported; clients use NewHTTPRequestContext and
+// HTTPRequestFromContext instead of using this key directly.
+const httpRequestContextKey httpRequestKey = 0
+
+// NewHTTPRequestContext returns a new Context that carries a (plain, not
+// CONNECT) HTTP request.
+func NewHTTPRequestContext(ctx context.Context, req *http.Request) context.Context {
+	return context.WithValue(ctx, httpRequestContextKey, req)
+}
+
+// HTTPRequestFromContext returns the HTTP request stored in ctx, if any.
+func HTTPRequestFromContext(ctx context.Context) (*http.Request, bool) {
+	if val := ctx.Value(httpRequestContextKey); nil == val {
+		return nil, false
+	} else if req, ok := val.(*http.Request); ok && nil != req {
+		return req, true
+	} else {
+		return nil, false
+	}
+}
+
+// "http://host[:port]/path": plain HTTP requests; a trailing '*' in the
+// path matches all paths with the given prefix
+type urlMatch struct {
+	Host   Matcher
+	Path   string
+	Prefix bool
+}
+
+func parseURLMatch(url string) (Matcher, error) {
+	if !strings.HasPrefix(url, "//") {
+		return nil, fmt.Errorf("Invalid URL pattern %q", "http:"+url)
+	}
+	url = url[2:]
+	var m urlMatch
+	host := url
+	if slash := strings.IndexByte(url, '/'); -1 != slash {
+		host = url[:slash]
+		m.Path = url[slash:]
+	}
+	if strings.HasSuffix(m.Path, "*") {
+		m.Path = m.Path[:len(m.Path)-1]
+		m.Prefix = true
+	}
+	if 0 == len(m.Path) {
+		m.Path = "/"
+		m.Prefix = true
+	}
+	if 0 == len(host) {
+		return nil, fmt.Errorf("Missing host in URL pattern %q", "http://"+url)
+	}
+	var err error
+	if m.Host, err = parseSimpleMatch(host); nil != err {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m urlMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	if req, ok := HTTPRequestFromContext(ctx); !ok {
+		return false
+	} else if !m.Host.Match(ctx, network, address) {
+		return false
+	} else if m.Prefix {
+		return strings.HasPrefix(req.URL.Path, m.Path)
+	} else {
+		return req.URL.Path == m.Path
+	}
+}
+
+func (m urlMatch) String() string {
+	if m.Prefix {
+		return fmt.Sprintf("http://%v%v*", m.Host, m.Path)
+	}
+	return fmt.Sprintf("http://%v%v", m.Host, m.Path)
+}
+
+// "method:POST": plain HTTP requests using the method
+type methodMatch struct {
+	Method string
+}
+
+func parseMethodMatch(method string) (Matcher, error) {
+	if 0 == len(method) {
+		return nil, fmt.Errorf("Missing HTTP method")
+	}
+	return methodMatch{Method: strings.ToUpper(method)}, nil
+}
+
+func (m methodMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	req, ok := HTTPRequestFromContext(ctx)
+	return ok && req.Method == m.Method
+}
+
+func (m methodMatch) String() string {
+	return "method:" + m.Method
+}
+
+// "header:Name" (header present) or "header:Name=value": plain HTTP
+// requests with the header (value)
+type headerMatch struct {
+	Name     string
+	Value    string
+	AnyValue bool
+}
+
+func parseHeaderMatch(header string) (Matcher, error) {
+	m := headerMatch{AnyValue: true}
+	if eq := strings.IndexByte(header, '='); -1 != eq {
+		m.Value = header[eq+1:]
+		m.AnyValue = false
+		header = header[:eq]
+	}
+	if 0 == len(header) {
+		return nil, fmt.Errorf("Missing HTTP header name")
+	}
+	m.Name = http.CanonicalHeaderKey(header)
+	return m, nil
+}
+
+func (m headerMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	if req, ok := HTTPRequestFromContext(ctx); !ok {
+		return false
+	} else if values, ok := req.Header[m.Name]; !ok {
+		return false
+	} else if m.AnyValue {
+		return true
+	} else {
+		for _, value := range values {
+			if value == m.Value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (m headerMatch) String() string {
+	if m.AnyValue {
+		return "header:" + m.Name
+	}
+	return fmt.Sprintf("header:%v=%v", m.Name, m.Value)
+}
@@ -0,0 +1,98 @@
+package routing
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// httpConnectDialer connects through an HTTP proxy using CONNECT;
+// "https://" proxies are connected to with TLS
+type httpConnectDialer struct {
+	Address string
+	TLS     bool
+	// "Proxy-Authorization" header (empty: none)
+	Authorization string
+}
+
+// http://[USER:PASSWORD@]HOST:PORT or https://...
+func parseHTTPConnectTarget(name string) (*Target, error) {
+	u, err := url.Parse(name)
+	if nil != err {
+		return nil, fmt.Errorf("Invalid target: %v", err)
+	} else if (0 != len(u.Path) && "/" != u.Path) || 0 != len(u.RawQuery) || 0 == len(u.Host) {
+		return nil, fmt.Errorf("Invalid target: %q", name)
+	}
+	d := httpConnectDialer{Address: u.Host, TLS: "https" == u.Scheme}
+	if 0 == len(u.Port()) {
+		if d.TLS {
+			d.Address = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			d.Address = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	if nil != u.User {
+		password, _ := u.User.Password()
+		d.Authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password))
+	}
+	// the name shows up in logs: no credentials
+	return &Target{Name: u.Scheme + "://" + d.Address, Dialer: d}, nil
+}
+
+func (d httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("HTTP proxies only support TCP, not %v", network)
+	}
+	conn, err := DirectTarget.Dialer.Dial("tcp", d.Address)
+	if nil != err {
+		return nil, err
+	}
+	if d.TLS {
+		host, _, _ := net.SplitHostPort(d.Address)
+		conn = tls.Client(conn, &tls.Config{ServerName: host})
+	}
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if 0 != len(d.Authorization) {
+		req.Header.Set("Proxy-Authorization", d.Authorization)
+	}
+	if err := req.Write(conn); nil != err {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if nil != err {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if http.StatusOK != resp.StatusCode {
+		conn.Close()
+		return nil, fmt.Errorf("Proxy %v refused connection to %v: %v", d.Address, address, resp.Status)
+	}
+	if reader.Buffered() > 0 {
+		return bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// keeps data read together with the proxy response
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
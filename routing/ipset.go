@@ -0,0 +1,207 @@
+package routing
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// IPv4 networks are stored with 4-byte addresses; networks within
+// ::ffff:0:0/96 (IPv4-mapped IPv6) are converted to IPv4
+func normalizeIPNet(n net.IPNet) net.IPNet {
+	if ones, bits := n.Mask.Size(); 128 == bits && ones >= 96 {
+		if ipv4 := n.IP.To4(); nil != ipv4 {
+			return net.IPNet{IP: ipv4, Mask: net.CIDRMask(ones-96, 32)}
+		}
+	} else if 32 == bits {
+		return net.IPNet{IP: n.IP.To4(), Mask: n.Mask}
+	}
+	return n
+}
+
+// IPv4 (and IPv4-mapped IPv6) addresses are only in IPv4 networks,
+// IPv6 addresses only in IPv6 networks; n needs to be normalized (see
+// normalizeIPNet)
+func containsIP(n net.IPNet, ip net.IP) bool {
+	if ipv4 := ip.To4(); nil != ipv4 {
+		return 4 == len(n.IP) && n.Contains(ipv4)
+	}
+	return net.IPv6len == len(n.IP) && n.Contains(ip)
+}
+
+// single IP address or network (CIDR), normalized
+func parseIPNet(s string) (net.IPNet, error) {
+	if ip := net.ParseIP(s); nil != ip {
+		return normalizeIPNet(net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))}), nil
+	} else if _, n, err := net.ParseCIDR(s); nil != err {
+		return net.IPNet{}, fmt.Errorf("Invalid IP address/network: %q", s)
+	} else {
+		return normalizeIPNet(*n), nil
+	}
+}
+
+// "FIRST-LAST" (inclusive)
+type ipRange struct {
+	First, Last net.IP
+}
+
+func parseIPRange(s string) (ipRange, error) {
+	dash := strings.IndexByte(s, '-')
+	first, last := net.ParseIP(s[:dash]), net.ParseIP(s[dash+1:])
+	if nil == first || nil == last {
+		return ipRange{}, fmt.Errorf("Invalid IP range: %q", s)
+	}
+	if ipv4 := first.To4(); nil != ipv4 {
+		first = ipv4
+	}
+	if ipv4 := last.To4(); nil != ipv4 {
+		last = ipv4
+	}
+	if len(first) != len(last) {
+		return ipRange{}, fmt.Errorf("IP range mixes IPv4 and IPv6: %q", s)
+	} else if bytes.Compare(first, last) > 0 {
+		return ipRange{}, fmt.Errorf("Empty IP range: %q", s)
+	}
+	return ipRange{First: first, Last: last}, nil
+}
+
+func (r ipRange) contains(ip net.IP) bool {
+	if ipv4 := ip.To4(); nil != ipv4 {
+		ip = ipv4
+	}
+	return len(ip) == len(r.First) && bytes.Compare(r.First, ip) <= 0 && bytes.Compare(ip, r.Last) <= 0
+}
+
+type ipSet struct {
+	Name   string
+	Nets   []net.IPNet
+	Ranges []ipRange
+}
+
+func (s *ipSet) contains(ip net.IP) bool {
+	for _, n := range s.Nets {
+		if containsIP(n, ip) {
+			return true
+		}
+	}
+	for _, r := range s.Ranges {
+		if r.contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// predefined sets, also usable in other sets
+var ipSetKeywords = map[string][]string{
+	"private":    {"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"},
+	"loopback":   {"127.0.0.0/8", "::1/128"},
+	"link-local": {"169.254.0.0/16", "fe80::/10"},
+	"cgnat":      {"100.64.0.0/10"},
+	"multicast":  {"224.0.0.0/4", "ff00::/8"},
+}
+
+// known set (defined earlier in the file or keyword)
+func (p *mapParser) ipSet(name string) (*ipSet, bool) {
+	if set, ok := p.ipsets[name]; ok {
+		return set, true
+	} else if entries, ok := ipSetKeywords[name]; ok {
+		set := &ipSet{Name: name}
+		for _, entry := range entries {
+			n, _ := parseIPNet(entry)
+			set.Nets = append(set.Nets, n)
+		}
+		return set, true
+	}
+	return nil, false
+}
+
+// adds an address, network, range, known set or file:PATH (one entry
+// per line) to set
+func (p *mapParser) addIPSetEntry(set *ipSet, entry string) error {
+	if other, ok := p.ipSet(entry); ok {
+		set.Nets = append(set.Nets, other.Nets...)
+		set.Ranges = append(set.Ranges, other.Ranges...)
+	} else if strings.HasPrefix(entry, "file:") {
+		return p.addIPSetFile(set, entry[5:])
+	} else if strings.Contains(entry, "-") {
+		if r, err := parseIPRange(entry); nil != err {
+			return err
+		} else {
+			set.Ranges = append(set.Ranges, r)
+		}
+	} else if n, err := parseIPNet(entry); nil != err {
+		return err
+	} else {
+		set.Nets = append(set.Nets, n)
+	}
+	return nil
+}
+
+func (p *mapParser) addIPSetFile(set *ipSet, filename string) error {
+	f, err := os.Open(filename)
+	if nil != err {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	linenum := 0
+	for scanner.Scan() {
+		linenum += 1
+		for _, entry := range strings.Fields(strings.Split(scanner.Text(), "#")[0]) {
+			if strings.HasPrefix(entry, "file:") {
+				return fmt.Errorf("Invalid line %v in %q: nested files not supported", linenum, filename)
+			} else if err := p.addIPSetEntry(set, entry); nil != err {
+				return fmt.Errorf("Invalid line %v in %q: %v", linenum, filename, err)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// @ipset NAME ENTRY...
+func (p *mapParser) parseIPSetDirective(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("Usage: @ipset NAME ENTRY...")
+	}
+	name := args[0]
+	if _, ok := p.ipSet(name); ok {
+		return fmt.Errorf("IP set %q already defined", name)
+	} else if strings.ContainsAny(name, ":/-,") {
+		return fmt.Errorf("Invalid IP set name %q", name)
+	}
+	set := &ipSet{Name: name}
+	for _, entry := range args[1:] {
+		if err := p.addIPSetEntry(set, entry); nil != err {
+			return err
+		}
+	}
+	p.ipsets[name] = set
+	return nil
+}
+
+// "set:NAME": IP address in the set
+type setMatch struct {
+	Set *ipSet
+}
+
+func (p *mapParser) parseSetMatch(name string) (Matcher, error) {
+	if set, ok := p.ipSet(name); !ok {
+		return nil, fmt.Errorf("Unknown IP set %q", name)
+	} else {
+		return setMatch{Set: set}, nil
+	}
+}
+
+func (m setMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	return nil != address.IP && m.Set.contains(address.IP)
+}
+
+func (m setMatch) String() string {
+	return "set:" + m.Set.Name
+}
@@ -0,0 +1,99 @@
+package routing
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestIPSetMatch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "office.txt")
+	if err := ioutil.WriteFile(file, []byte("# office networks\n198.51.100.0/24 203.0.113.7\n\n2001:db8:1::/48 # v6\n"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	m, err := ReadMap(strings.NewReader(`@ipset range 192.0.2.10-192.0.2.20 2001:db8::10-2001:db8::20
+@ipset office file:` + file + `
+@ipset internal office private cgnat
+@ipset mapped ::ffff:10.0.0.0/104
+set:range socks5://127.0.0.1:1
+set:internal socks5://127.0.0.1:2
+set:loopback socks5://127.0.0.1:3
+set:mapped socks5://127.0.0.1:4
+::ffff:192.0.2.128/121 socks5://127.0.0.1:5
+`))
+	if nil != err {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		address string
+		route   string
+	}{
+		// ranges are inclusive
+		{"192.0.2.10:80", "socks5://127.0.0.1:1"},
+		{"192.0.2.15:80", "socks5://127.0.0.1:1"},
+		{"192.0.2.20:80", "socks5://127.0.0.1:1"},
+		{"192.0.2.21:80", "direct"},
+		{"192.0.2.9:80", "direct"},
+		{"[2001:db8::18]:80", "socks5://127.0.0.1:1"},
+		{"[2001:db8::21]:80", "direct"},
+		// IPv4-mapped addresses are IPv4 addresses
+		{"[::ffff:192.0.2.12]:80", "socks5://127.0.0.1:1"},
+		// file and keywords in another set
+		{"198.51.100.1:80", "socks5://127.0.0.1:2"},
+		{"203.0.113.7:80", "socks5://127.0.0.1:2"},
+		{"203.0.113.8:80", "direct"},
+		{"[2001:db8:1::1]:80", "socks5://127.0.0.1:2"},
+		{"10.1.2.3:80", "socks5://127.0.0.1:2"},
+		{"172.31.0.1:80", "socks5://127.0.0.1:2"},
+		{"100.64.0.1:80", "socks5://127.0.0.1:2"},
+		{"[fd00::1]:80", "socks5://127.0.0.1:2"},
+		{"127.0.0.1:80", "socks5://127.0.0.1:3"},
+		{"[::1]:80", "socks5://127.0.0.1:3"},
+		// mapped networks are IPv4 networks; they don't match IPv6
+		// addresses outside ::ffff:0:0/96
+		{"192.0.2.130:80", "socks5://127.0.0.1:5"},
+		{"[::ffff:192.0.2.130]:80", "socks5://127.0.0.1:5"},
+		{"[::c000:282]:80", "direct"},
+		// sets only match IP addresses
+		{"www.example.com:80", "direct"},
+	}
+	for _, test := range tests {
+		if route := routeFor(m, test.address); route != test.route {
+			t.Errorf("%v: got %v, want %v", test.address, route, test.route)
+		}
+	}
+
+	// ::ffff:10.0.0.0/104 is 10.0.0.0/8
+	set, _ := m.Tables[MainTable].Routes[3].(matchRoute).Matcher.(setMatch)
+	if 1 != len(set.Set.Nets) || "10.0.0.0/8" != set.Set.Nets[0].String() {
+		t.Errorf("mapped network: got %v, want 10.0.0.0/8", set.Set.Nets)
+	}
+	ad, _ := ParseAddress("10.9.8.7:80")
+	if !set.Match(context.Background(), "tcp", *ad) {
+		t.Error("mapped network doesn't match IPv4 address")
+	}
+}
+
+func TestIPSetInvalid(t *testing.T) {
+	tests := []string{
+		"@ipset empty\n",
+		"@ipset bad 192.0.2.1/33\n",
+		"@ipset bad 192.0.2.20-192.0.2.10\n",
+		"@ipset bad 192.0.2.1-2001:db8::1\n",
+		"@ipset bad 192.0.2.1-\n",
+		"@ipset bad unknown-set\n",
+		"@ipset bad file:/nonexistent/file\n",
+		"@ipset private 192.0.2.0/24\n",
+		"@ipset a 192.0.2.0/24\n@ipset a 198.51.100.0/24\n",
+		"@ipset a:b 192.0.2.0/24\n",
+		"set:unknown direct\n",
+	}
+	for _, config := range tests {
+		if _, err := ReadMap(strings.NewReader(config)); nil == err {
+			t.Errorf("%q: no error", config)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package routing
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rus-cert/socks-router/log"
+)
+
+// Learned remembers destinations "auto:" targets couldn't reach
+// directly; needs to be set before reading a Map using those
+var Learned *LearnedRoutes
+
+const learnedReloadInterval = time.Second
+
+type LearnedRoute struct {
+	Address string // host:port as requested
+	Via     string // name of the target that worked
+	Expires time.Time
+}
+
+func (r LearnedRoute) String() string {
+	return fmt.Sprintf("%v over %v (expires %v)", r.Address, r.Via, r.Expires.Format(time.RFC3339))
+}
+
+// LearnedRoutes is persisted in a file (one "ADDRESS VIA EXPIRES" line
+// per route); the file is reloaded when it changes
+type LearnedRoutes struct {
+	Filename string
+	// how long routes are remembered
+	Expiry time.Duration
+
+	mutex   sync.Mutex
+	routes  map[string]LearnedRoute
+	modTime time.Time
+}
+
+// OpenLearnedRoutes loads the file (if it exists); watch enables
+// reloading when the file is modified by someone else
+func OpenLearnedRoutes(filename string, expiry time.Duration, watch bool) (*LearnedRoutes, error) {
+	l := &LearnedRoutes{
+		Filename: filename,
+		Expiry:   expiry,
+		routes:   make(map[string]LearnedRoute),
+	}
+	if err := l.load(); nil != err {
+		return nil, err
+	}
+	if watch {
+		go l.watch()
+	}
+	return l, nil
+}
+
+// needs the lock
+func (l *LearnedRoutes) load() error {
+	f, err := os.Open(l.Filename)
+	if os.IsNotExist(err) {
+		l.routes = make(map[string]LearnedRoute)
+		l.modTime = time.Time{}
+		return nil
+	} else if nil != err {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if nil != err {
+		return err
+	}
+
+	routes := make(map[string]LearnedRoute)
+	scanner := bufio.NewScanner(f)
+	linenum := 0
+	for scanner.Scan() {
+		linenum += 1
+		line := strings.TrimSpace(scanner.Text())
+		if 0 == len(line) || '#' == line[0] {
+			continue
+		}
+		fields := strings.Fields(line)
+		if 3 != len(fields) {
+			return fmt.Errorf("Invalid line %v in %q", linenum, l.Filename)
+		}
+		expires, err := time.Parse(time.RFC3339, fields[2])
+		if nil != err {
+			return fmt.Errorf("Invalid line %v in %q: %v", linenum, l.Filename, err)
+		}
+		routes[fields[0]] = LearnedRoute{
+			Address: fields[0],
+			Via:     fields[1],
+			Expires: expires,
+		}
+	}
+	if err := scanner.Err(); nil != err {
+		return err
+	}
+	l.routes = routes
+	l.modTime = fi.ModTime()
+	return nil
+}
+
+// needs the lock; drops expired routes
+func (l *LearnedRoutes) save() error {
+	var lines []string
+	now := time.Now()
+	for address, route := range l.routes {
+		if now.After(route.Expires) {
+			delete(l.routes, address)
+		} else {
+			lines = append(lines, fmt.Sprintf("%v %v %v\n", route.Address, route.Via, route.Expires.Format(time.RFC3339)))
+		}
+	}
+	sort.Strings(lines)
+
+	dir := filepath.Dir(l.Filename)
+	if err := os.MkdirAll(dir, 0755); nil != err {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".learned")
+	if nil != err {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.Join(lines, "")); nil != err {
+		tmp.Close()
+		return err
+	} else if err := tmp.Close(); nil != err {
+		return err
+	} else if err := os.Chmod(tmp.Name(), 0644); nil != err {
+		return err
+	} else if err := os.Rename(tmp.Name(), l.Filename); nil != err {
+		return err
+	}
+	if fi, err := os.Stat(l.Filename); nil == err {
+		l.modTime = fi.ModTime()
+	}
+	return nil
+}
+
+func (l *LearnedRoutes) watch() {
+	for range time.Tick(learnedReloadInterval) {
+		l.mutex.Lock()
+		fi, err := os.Stat(l.Filename)
+		if (nil == err && !fi.ModTime().Equal(l.modTime)) || (os.IsNotExist(err) && !l.modTime.IsZero()) {
+			if err := l.load(); nil != err {
+				log.Error.Printf("Couldn't reload learned routes: %v", err)
+			} else {
+				log.Info.Printf("Reloaded learned routes %q", l.Filename)
+			}
+		}
+		l.mutex.Unlock()
+	}
+}
+
+// needs the lock; picks up changes by someone else (e.g. "learned
+// forget") before save overwrites them
+func (l *LearnedRoutes) reload() {
+	if err := l.load(); nil != err {
+		log.Error.Printf("Couldn't reload learned routes: %v", err)
+	}
+}
+
+// List returns the routes which haven't expired yet
+func (l *LearnedRoutes) List() []LearnedRoute {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var routes []LearnedRoute
+	now := time.Now()
+	for _, route := range l.routes {
+		if now.Before(route.Expires) {
+			routes = append(routes, route)
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Address < routes[j].Address
+	})
+	return routes
+}
+
+// Forget removes the routes for an address (HOST:PORT, or HOST for all
+// ports); returns the number of routes removed
+func (l *LearnedRoutes) Forget(address string) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.reload()
+	removed := 0
+	for key := range l.routes {
+		if host, _, err := net.SplitHostPort(key); key == address || (nil == err && host == address) {
+			delete(l.routes, key)
+			removed += 1
+		}
+	}
+	if 0 == removed {
+		return 0, nil
+	}
+	return removed, l.save()
+}
+
+// ForgetAll removes all routes
+func (l *LearnedRoutes) ForgetAll() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.routes = make(map[string]LearnedRoute)
+	return l.save()
+}
+
+func (l *LearnedRoutes) lookup(address string) (LearnedRoute, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	route, ok := l.routes[address]
+	if ok && time.Now().After(route.Expires) {
+		return route, false
+	}
+	return route, ok
+}
+
+func (l *LearnedRoutes) learn(address, via string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.reload()
+	l.routes[address] = LearnedRoute{
+		Address: address,
+		Via:     via,
+		Expires: time.Now().Add(l.Expiry),
+	}
+	return l.save()
+}
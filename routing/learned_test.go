@@ -0,0 +1,38 @@
+package routing
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLearnedForget(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "learned")
+	router, err := OpenLearnedRoutes(file, time.Hour, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err := router.learn("a.example.com:443", "vpn"); nil != err {
+		t.Fatal(err)
+	}
+	// "learned forget" in another process
+	cli, err := OpenLearnedRoutes(file, time.Hour, false)
+	if nil != err {
+		t.Fatal(err)
+	} else if removed, err := cli.Forget("a.example.com"); nil != err || 1 != removed {
+		t.Fatalf("forget: %v, %v", removed, err)
+	}
+	// the router writes the file again before noticing the change
+	if err := router.learn("b.example.com:443", "vpn"); nil != err {
+		t.Fatal(err)
+	}
+
+	l, err := OpenLearnedRoutes(file, time.Hour, false)
+	if nil != err {
+		t.Fatal(err)
+	}
+	routes := l.List()
+	if 1 != len(routes) || "b.example.com:443" != routes[0].Address {
+		t.Errorf("got %v, want only b.example.com:443", routes)
+	}
+}
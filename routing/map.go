@@ -6,49 +6,258 @@ import (
 	"io"
 	"net"
 	"os"
+	"sort"
+
+	"golang.org/x/net/context"
 
 	"github.com/rus-cert/socks-router/log"
+	"github.com/rus-cert/socks-router/stubresolver"
 )
 
-// first match wins
+// routing configuration: routes organized in tables, starting with the
+// table of the active profile (if there are profiles) and then the main
+// table (see MainTable)
 type Map struct {
-	Routes []Route
+	Tables map[string]*Table
+	// named targets
+	Targets map[string]*Target
+	// shared between copies of the Map
+	profiles *profileState
+	// maximum CNAME chain length to follow for domain matches (0: off)
+	cnameDepth int
+	// address overrides (@host, @hosts)
+	hosts map[string]hostEntry
+	// destination rewrites (@rewrite)
+	rewrites []rewriteRule
+	// local network state for conditions (see Start)
+	netState *networkState
+	// downloaded rules (@subscribe; see Start)
+	subscriptions []*subscription
+}
+
+// Decide evaluates the routes for a request; fails if the client
+// selected a target it isn't allowed to
+func (m Map) Decide(ctx context.Context, network string, address AddressDetails) (Decision, error) {
+	var d Decision
+	if target, err := m.selectedTarget(ctx); nil != err {
+		return d, err
+	} else if nil != target {
+		d.Target = target
+		d.Selected = true
+	} else {
+		m.addAliases(ctx, &address)
+		d.Profile = m.ActiveProfile()
+		if 0 == len(d.Profile) || !m.Tables[d.Profile].decide(ctx, network, address, &d) {
+			if main, ok := m.Tables[MainTable]; ok {
+				main.decide(ctx, network, address, &d)
+			}
+		}
+	}
+	if nil != d.Target && nil != d.Target.Tunnel {
+		splitHorizon(ctx, address, &d)
+	}
+	return d, nil
+}
+
+// Start watches the local network state for conditions ("iface:",
+// "local:", "reachable:"; also selecting profiles by their conditions)
+// and downloads subscriptions in the background (until then cached
+// versions are used); only needed for long running processes.  Call once
+// after reading the Map.
+func (m Map) Start() {
+	if m.netState.isUsed() {
+		m.netState.start()
+	}
+	for _, s := range m.subscriptions {
+		s.start()
+	}
+}
+
+// CheckConditions evaluates the conditions once (without watching for
+// changes)
+func (m Map) CheckConditions() {
+	if m.netState.isUsed() {
+		m.netState.update()
+	}
+}
+
+func (m Map) Match(ctx context.Context, network string, address AddressDetails) *Target {
+	if d, err := m.Decide(ctx, network, address); nil != err {
+		return nil
+	} else {
+		return d.Target
+	}
 }
 
-func (m Map) Match(network string, address AddressDetails) *Target {
-	for _, route := range m.Routes {
-		if target := route.Match(network, address); nil != target {
-			return target
+// table names: main table first, others sorted
+func (m Map) tableNames() []string {
+	var names []string
+	for name := range m.Tables {
+		if MainTable != name {
+			names = append(names, name)
 		}
 	}
-	return nil
+	sort.Strings(names)
+	if _, ok := m.Tables[MainTable]; ok {
+		names = append([]string{MainTable}, names...)
+	}
+	return names
+}
+
+// Stats returns the usage counters of all routes
+func (m Map) Stats() []RouteStats {
+	var stats []RouteStats
+	for _, name := range m.tableNames() {
+		stats = m.Tables[name].appendStats(stats, name)
+	}
+	// active version of subscribed rules, labelled by URL
+	for _, s := range m.subscriptions {
+		stats = s.table().appendStats(stats, s.URL)
+	}
+	return stats
 }
 
 func (m Map) Dial(network, address string) (c net.Conn, err error) {
+	return m.DialContext(context.Background(), network, address)
+}
+
+// parse address, adding the hostname from the context for IP addresses;
+// also returns a description of the destination for logging
+func requestDetails(ctx context.Context, address string) (*AddressDetails, string, error) {
 	if ad, err := ParseAddress(address); nil != err {
-		return nil, err
+		return nil, "", err
 	} else {
-		var dial func(network, address string) (c net.Conn, err error)
-		var desc string
-		if target := m.Match(network, *ad); nil != target {
-			desc = fmt.Sprintf("to %v over %v", address, target.Name)
-			dial = target.Dialer.Dial
-		} else {
-			desc = fmt.Sprintf("directly to %v", address)
-			dial = DirectTarget.Dialer.Dial
+		dest := ad.Address
+		if fqdn, ok := stubresolver.FqdnFromContext(ctx); ok && nil != ad.IP {
+			if fqdn, err := NormalizeHostname(fqdn); nil != err {
+				log.Debug.Printf("Ignoring hostname for %v: %v", ad.Address, err)
+			} else {
+				ad.FQDN = fqdn
+				dest = fmt.Sprintf("%v (%v)", ad.Address, fqdn)
+			}
 		}
-		log.Access.Printf("connecting %v", desc)
-		if conn, err := dial(network, address); nil != err {
-			log.Error.Printf("Failed to connect %v: %v", desc, err)
+		return ad, dest, nil
+	}
+}
+
+// apply @rewrite and @host/@hosts (before mode) to a request; updates
+// the description for logging
+func (m Map) prepare(ad *AddressDetails, dest string) (string, error) {
+	if rewritten, err := m.rewrite(ad); nil != err {
+		return dest, err
+	} else if rewritten {
+		dest = fmt.Sprintf("%v (rewritten to %v)", dest, ad.Address)
+	}
+	if ip := m.applyHosts(ad, false); nil != ip {
+		dest = fmt.Sprintf("%v (hosts: %v)", dest, ip)
+	}
+	return dest, nil
+}
+
+// Prepare parses the address of a request and applies @rewrite and
+// @host/@hosts (before mode) like DialContext does before deciding the
+// route; returns the address to decide the route for and a description
+// for logging
+func (m Map) Prepare(ctx context.Context, address string) (*AddressDetails, string, error) {
+	ad, dest, err := requestDetails(ctx, address)
+	if nil != err {
+		return nil, "", err
+	}
+	dest, err = m.prepare(ad, dest)
+	return ad, dest, err
+}
+
+// a request decided up front (see Route)
+type decidedRequest struct {
+	network string
+	address string
+	ad      AddressDetails
+	dest    string
+	d       Decision
+}
+
+type decidedKey int
+
+const decidedContextKey decidedKey = 0
+
+// Route decides the route of a request up front; returns the name of the
+// target and a context making DialContext (for the same network and
+// address) use exactly this decision
+func (m Map) Route(ctx context.Context, network, address string) (context.Context, string) {
+	ad, dest, err := requestDetails(ctx, address)
+	if nil != err {
+		return ctx, DirectTarget.Name
+	} else if dest, err = m.prepare(ad, dest); nil != err {
+		return ctx, ""
+	}
+	if d, err := m.Decide(ctx, network, *ad); nil != err {
+		return ctx, ""
+	} else {
+		decided := &decidedRequest{network: network, address: address, ad: *ad, dest: dest, d: d}
+		return context.WithValue(ctx, decidedContextKey, decided), d.TargetName()
+	}
+}
+
+// RouteName returns the name of the target a request would be routed to
+func (m Map) RouteName(ctx context.Context, network, address string) string {
+	_, name := m.Route(ctx, network, address)
+	return name
+}
+
+// DialContext routes requests for IP addresses by the hostname in the
+// context (see stubresolver.NewFqdnContext) if available; the context is
+// also passed to the routes (see NewClientContext)
+func (m Map) DialContext(ctx context.Context, network, address string) (c net.Conn, err error) {
+	if decided, ok := ctx.Value(decidedContextKey).(*decidedRequest); ok && decided.network == network && decided.address == address {
+		ad := decided.ad
+		return m.dial(ctx, network, &ad, decided.dest, decided.d)
+	}
+	if ad, dest, err := requestDetails(ctx, address); nil != err {
+		return nil, err
+	} else if dest, err := m.prepare(ad, dest); nil != err {
+		log.Error.Printf("Rejected connection to %v: %v", dest, err)
+		return nil, err
+	} else if d, err := m.Decide(ctx, network, *ad); nil != err {
+		log.Error.Printf("Rejected connection to %v: %v", dest, err)
+		return nil, err
+	} else {
+		return m.dial(ctx, network, ad, dest, d)
+	}
+}
+
+// connect a decided request
+func (m Map) dial(ctx context.Context, network string, ad *AddressDetails, dest string, d Decision) (net.Conn, error) {
+	d.countMatch()
+	if &rejectTarget == d.Target {
+		err := fmt.Errorf("Rejected by %v", d.Route)
+		log.Error.Printf("Rejected connection to %v: %v", dest, err)
+		return nil, err
+	}
+	if ip := m.applyHosts(ad, true); nil != ip {
+		dest = fmt.Sprintf("%v (hosts: %v)", dest, ip)
+	} else if ResolveLocal == d.Options.Resolve && nil == ad.IP {
+		if err := resolveLocally(ctx, ad); nil != err {
+			log.Error.Printf("Couldn't resolve %v: %v", dest, err)
 			return nil, err
-		} else {
-			return conn, nil
 		}
+		dest = fmt.Sprintf("%v (%v)", dest, ad.IP)
+	}
+	desc := fmt.Sprintf("to %v %v", dest, d)
+	if !d.Options.Quiet {
+		log.Access.Printf("connecting %v", desc)
+	}
+	conn, err := dialTimeout(ctx, d.dialer(), d.Options.Timeout, network, ad.Address)
+	d.counter.dial(err)
+	if nil != err {
+		log.Error.Printf("Failed to connect %v: %v", desc, err)
+		return nil, err
+	} else {
+		return conn, nil
 	}
 }
 
 func ReadMap(r io.Reader) (*Map, error) {
-	var m Map
+	p := newMapParser()
 
 	scanner := bufio.NewScanner(r)
 	linenum := 0
@@ -56,14 +265,12 @@ func ReadMap(r io.Reader) (*Map, error) {
 	for scanner.Scan() {
 		line := scanner.Text()
 		linenum += 1
-		if r, err := ParseRoute(line); nil != err {
+		if err := p.parseLine(line); nil != err {
 			return nil, fmt.Errorf("Error in config line %v: %v", linenum, err)
-		} else if nil != r {
-			m.Routes = append(m.Routes, r)
 		}
 	}
 
-	return &m, nil
+	return p.finish()
 }
 
 func ReadMapFile(filename string) (*Map, error) {
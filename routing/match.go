@@ -0,0 +1,286 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+type Matcher interface {
+	// ctx carries details about the request, like the client (see
+	// NewClientContext)
+	Match(ctx context.Context, network string, address AddressDetails) bool
+}
+
+// ParseMatch parses the "match" column of a route: a comma separated
+// list of patterns which all need to match; a leading '!' negates a
+// pattern.  For example ".example.com,!www.example.com"
+func ParseMatch(match string) (Matcher, error) {
+	return newMapParser().parseMatch(match)
+}
+
+// like ParseMatch, with access to the IP sets defined in the file
+func (p *mapParser) parseMatch(match string) (Matcher, error) {
+	var matchers allMatch
+	for _, pattern := range strings.Split(match, ",") {
+		if m, err := p.parseNegatableMatch(pattern); nil != err {
+			return nil, err
+		} else {
+			matchers = append(matchers, m)
+		}
+	}
+	if 1 == len(matchers) {
+		return matchers[0], nil
+	}
+	return matchers, nil
+}
+
+func (p *mapParser) parseNegatableMatch(pattern string) (Matcher, error) {
+	if strings.HasPrefix(pattern, "!") {
+		if m, err := p.parsePattern(pattern[1:]); nil != err {
+			return nil, err
+		} else {
+			return negatedMatch{m}, nil
+		}
+	}
+	return p.parsePattern(pattern)
+}
+
+// patterns starting with "type:" ("set:" and the network conditions
+// "iface:", "local:" and "reachable:" are handled by the parser);
+// everything else is handled by parseSimpleMatch
+var patternTypes = map[string]func(string) (Matcher, error){
+	"geo":    parseGeoMatch,
+	"asn":    parseASNMatch,
+	"client": parseClientMatch,
+	"uid":    parseUIDMatch,
+	"exe":    parseExeMatch,
+
+	"http":   parseURLMatch,
+	"method": parseMethodMatch,
+	"header": parseHeaderMatch,
+}
+
+// a single (not negated) pattern
+func (p *mapParser) parsePattern(pattern string) (Matcher, error) {
+	if 0 == len(pattern) {
+		return nil, fmt.Errorf("Empty match pattern")
+	}
+	typ, value := splitPatternType(pattern)
+	switch typ {
+	case "set":
+		return p.parseSetMatch(value)
+	case "iface":
+		return p.parseIfaceMatch(value)
+	case "local":
+		return p.parseLocalMatch(value)
+	case "reachable":
+		return p.parseReachableMatch(value)
+	}
+	if parse, ok := patternTypes[typ]; ok {
+		return parse(value)
+	}
+	return parseSimpleMatch(pattern)
+}
+
+// types taking numbers: "uid:1000" and "asn:64496" are always typed
+// patterns; for other types "TYPE:PORT" stays a single-label hostname
+// with a port (e.g. "local:80")
+var numericPatternTypes = map[string]bool{"uid": true, "asn": true}
+
+// type ("" for hostname and network patterns) and value of a pattern
+func splitPatternType(pattern string) (string, string) {
+	colon := strings.IndexByte(pattern, ':')
+	if -1 == colon {
+		return "", pattern
+	}
+	typ, value := pattern[:colon], pattern[colon+1:]
+	if isPlainPort(value) && !numericPatternTypes[typ] {
+		return "", pattern
+	}
+	return typ, value
+}
+
+func isPlainPort(s string) bool {
+	if 0 == len(s) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || '9' < s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// all matchers need to match
+type allMatch []Matcher
+
+func (m allMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	for _, matcher := range m {
+		if !matcher.Match(ctx, network, address) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m allMatch) String() string {
+	patterns := make([]string, len(m))
+	for i, matcher := range m {
+		patterns[i] = fmt.Sprint(matcher)
+	}
+	return strings.Join(patterns, ",")
+}
+
+// matches everything the inner matcher doesn't match
+type negatedMatch struct {
+	Matcher Matcher
+}
+
+func (m negatedMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	return !m.Matcher.Match(ctx, network, address)
+}
+
+func (m negatedMatch) String() string {
+	return fmt.Sprintf("!%v", m.Matcher)
+}
+
+type cidrMatch struct {
+	CIDR net.IPNet
+	Port string
+}
+
+func (m cidrMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	return nil != address.IP && containsIP(m.CIDR, address.IP) && (0 == len(m.Port) || m.Port == address.Port)
+}
+
+func (m cidrMatch) String() string {
+	if 0 != len(m.Port) {
+		return fmt.Sprintf("[%v]:%v", m.CIDR.String(), m.Port)
+	}
+	return m.CIDR.String()
+}
+
+type domainMatch struct {
+	Domain string
+	Port   string
+}
+
+func removeTrailingDot(fqdn string) string {
+	// remove trailing dot
+	if strings.HasSuffix(fqdn, ".") {
+		return fqdn[:len(fqdn)-1]
+	} else {
+		return fqdn
+	}
+}
+
+// address.FQDN must be normalized (see ParseAddress)
+func (m domainMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	if 0 != len(address.FQDN) && (0 == len(m.Port) || m.Port == address.Port) {
+		if m.matchName(address.FQDN) {
+			return true
+		}
+		for _, alias := range address.Aliases {
+			if m.matchName(alias) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m domainMatch) matchName(fqdn string) bool {
+	if "*" == m.Domain {
+		return true
+	} else if '.' == m.Domain[0] {
+		return m.Domain[1:] == fqdn || strings.HasSuffix(fqdn, m.Domain)
+	} else {
+		return m.Domain == fqdn
+	}
+}
+
+func (m domainMatch) String() string {
+	if 0 != len(m.Port) {
+		return fmt.Sprintf("%v:%v", m.Domain, m.Port)
+	}
+	return m.Domain
+}
+
+func parseSimpleMatch(match string) (Matcher, error) {
+	var network string
+	var host string
+	var port string
+
+	if '[' == match[0] {
+		if rbracket := strings.LastIndex(match, "]"); -1 == rbracket {
+			return nil, fmt.Errorf("Missing closing ']' in %q", match)
+		} else {
+			network = match[1:rbracket]
+			if len(match) > rbracket+1 {
+				if ':' != match[rbracket+1] {
+					return nil, fmt.Errorf("Only ':' allowed after ']' in %q", match)
+				}
+				port = match[rbracket+2:]
+			}
+		}
+	} else if slash := strings.IndexRune(match, '/'); -1 != slash {
+		if lastcolon := strings.LastIndex(match, ":"); lastcolon > slash {
+			network = match[:lastcolon]
+			port = match[lastcolon+1:]
+		} else {
+			network = match
+		}
+	} else if colon := strings.IndexRune(match, ':'); -1 != colon {
+		if lastcolon := strings.LastIndex(match, ":"); lastcolon > colon {
+			// 2 or more colons: always interpret as IPv6 address
+			network = match
+		} else {
+			// single colon: not an IPv6 address, so split port
+			host = match[:colon]
+			port = match[colon+1:]
+		}
+	} else {
+		host = match
+	}
+
+	var ipnet net.IPNet
+	if 0 != len(host) && nil != net.ParseIP(host) {
+		// cannot be CIDR, but could be single IP address
+		network = host
+		host = ""
+	}
+	if 0 == len(host) {
+		var err error
+		if ipnet, err = parseIPNet(network); nil != err {
+			return nil, err
+		}
+	}
+
+	if 0 != len(host) {
+		if "*" != host {
+			var err error
+			if '.' == host[0] {
+				host, err = NormalizeHostname(host[1:])
+				host = "." + host
+			} else {
+				host, err = NormalizeHostname(host)
+			}
+			if nil != err {
+				return nil, err
+			}
+		}
+		return domainMatch{
+			Domain: host,
+			Port:   port,
+		}, nil
+	} else {
+		return cidrMatch{
+			CIDR: ipnet,
+			Port: port,
+		}, nil
+	}
+}
@@ -0,0 +1,87 @@
+package routing
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestPatternTypes(t *testing.T) {
+	tests := []struct {
+		pattern string
+		kind    string
+	}{
+		// single-label hostnames with a port
+		{"local:80", "routing.domainMatch"},
+		{"client:8080", "routing.domainMatch"},
+		{"iface:443", "routing.domainMatch"},
+		{"http:80", "routing.domainMatch"},
+		{"method:80", "routing.domainMatch"},
+		{"header:80", "routing.domainMatch"},
+		{"set:80", "routing.domainMatch"},
+		{"local:192.168.0.0/16", "routing.localMatch"},
+		{"client:10.0.0.0/8", "routing.clientMatch"},
+		{"iface:eth0", "routing.ifaceMatch"},
+		{"method:post", "routing.methodMatch"},
+		{"uid:1000", "routing.uidMatch"},
+	}
+	for _, test := range tests {
+		if m, err := newMapParser().parsePattern(test.pattern); nil != err {
+			t.Errorf("%v: %v", test.pattern, err)
+		} else if kind := fmt.Sprintf("%T", m); kind != test.kind {
+			t.Errorf("%v: got %v, want %v", test.pattern, kind, test.kind)
+		}
+	}
+}
+
+func TestCompoundMatch(t *testing.T) {
+	tests := []struct {
+		match   string
+		address string
+		fqdn    string
+		want    bool
+	}{
+		{".corp.example.com,!public.corp.example.com", "www.corp.example.com:80", "", true},
+		{".corp.example.com,!public.corp.example.com", "public.corp.example.com:80", "", false},
+		{".corp.example.com,!public.corp.example.com", "www.example.com:80", "", false},
+		{"10.0.0.0/8,!10.1.0.0/16", "10.2.3.4:80", "", true},
+		{"10.0.0.0/8,!10.1.0.0/16", "10.1.3.4:80", "", false},
+		{"10.0.0.0/8,!10.1.0.0/16", "192.0.2.1:80", "", false},
+		// negations match requests of the other kind (IP vs hostname)
+		{"!10.0.0.0/8", "www.example.com:80", "", true},
+		{"!.example.com", "192.0.2.1:80", "", true},
+		{"!.example.com", "www.example.com:80", "", false},
+		{"!*:22", "www.example.com:22", "", false},
+		{"!*:22", "www.example.com:80", "", true},
+		// hostname and IP address of a sniffed request
+		{".example.com,10.0.0.0/8", "10.1.2.3:443", "www.example.com", true},
+		{".example.com,10.0.0.0/8", "192.0.2.1:443", "www.example.com", false},
+		{".example.com,!10.0.0.0/8", "10.1.2.3:443", "www.example.com", false},
+	}
+	for _, test := range tests {
+		m, err := newMapParser().parseMatch(test.match)
+		if nil != err {
+			t.Errorf("%v: %v", test.match, err)
+			continue
+		}
+		ad, err := ParseAddress(test.address)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if 0 != len(test.fqdn) {
+			ad.FQDN = test.fqdn
+		}
+		if got := m.Match(context.Background(), "tcp", *ad); got != test.want {
+			t.Errorf("%v for %v (%v): got %v, want %v", test.match, test.address, test.fqdn, got, test.want)
+		}
+	}
+}
+
+func TestCompoundMatchInvalid(t *testing.T) {
+	for _, match := range []string{"", ",", ".example.com,", "!", "!!.example.com", ".example.com,!"} {
+		if m, err := newMapParser().parseMatch(match); nil == err {
+			t.Errorf("%q: got %v, want error", match, m)
+		}
+	}
+}
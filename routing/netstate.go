@@ -0,0 +1,254 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/rus-cert/socks-router/log"
+)
+
+const reachableTimeout = 2 * time.Second
+const reachableCheckInterval = 30 * time.Second
+
+// delay updates after a change notification; changes usually come in
+// bursts
+const netStateUpdateDelay = 500 * time.Millisecond
+
+// cached local network state for the conditions of a Map; updated when
+// the network configuration changes (see watchNetwork) once started
+type networkState struct {
+	mutex      sync.RWMutex
+	interfaces map[string]bool // name -> up
+	addrs      []net.IP
+	// "host:port" -> reachable
+	reachable map[string]bool
+
+	// conditions were parsed; only watched if used
+	used      bool
+	startOnce sync.Once
+	listeners []func()
+}
+
+func newNetworkState() *networkState {
+	return &networkState{
+		interfaces: make(map[string]bool),
+		reachable:  make(map[string]bool),
+	}
+}
+
+func (s *networkState) use() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.used = true
+}
+
+func (s *networkState) isUsed() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.used
+}
+
+// needs to be called before start
+func (s *networkState) watchReachable(address string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.used = true
+	if _, ok := s.reachable[address]; !ok {
+		s.reachable[address] = false
+	}
+}
+
+// called after each update
+func (s *networkState) onChange(f func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.listeners = append(s.listeners, f)
+}
+
+// initial (synchronous) update, then start watching for changes
+func (s *networkState) start() {
+	s.startOnce.Do(func() {
+		s.update()
+		changes := make(chan struct{}, 1)
+		go watchNetwork(changes)
+		go func() {
+			ticker := time.NewTicker(reachableCheckInterval)
+			for {
+				select {
+				case <-changes:
+					time.Sleep(netStateUpdateDelay)
+					// drop notifications which arrived meanwhile
+					select {
+					case <-changes:
+					default:
+					}
+				case <-ticker.C:
+				}
+				s.update()
+			}
+		}()
+	})
+}
+
+func (s *networkState) update() {
+	interfaces := make(map[string]bool)
+	var addrs []net.IP
+	if ifaces, err := net.Interfaces(); nil != err {
+		log.Error.Printf("Couldn't list network interfaces: %v", err)
+	} else {
+		for _, iface := range ifaces {
+			interfaces[iface.Name] = 0 != iface.Flags&net.FlagUp
+		}
+	}
+	if ifaddrs, err := net.InterfaceAddrs(); nil != err {
+		log.Error.Printf("Couldn't list network addresses: %v", err)
+	} else {
+		for _, addr := range ifaddrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				addrs = append(addrs, ipnet.IP)
+			}
+		}
+	}
+
+	s.mutex.RLock()
+	var targets []string
+	for address := range s.reachable {
+		targets = append(targets, address)
+	}
+	s.mutex.RUnlock()
+
+	reachable := make(map[string]bool)
+	var wg sync.WaitGroup
+	var reachableMutex sync.Mutex
+	for _, address := range targets {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			conn, err := net.DialTimeout("tcp", address, reachableTimeout)
+			if nil == err {
+				conn.Close()
+			}
+			reachableMutex.Lock()
+			defer reachableMutex.Unlock()
+			reachable[address] = nil == err
+		}(address)
+	}
+	wg.Wait()
+
+	s.mutex.Lock()
+	s.interfaces = interfaces
+	s.addrs = addrs
+	for address, ok := range reachable {
+		if ok != s.reachable[address] {
+			log.Info.Printf("%v now reachable: %v", address, ok)
+		}
+		s.reachable[address] = ok
+	}
+	listeners := s.listeners
+	s.mutex.Unlock()
+
+	for _, f := range listeners {
+		f()
+	}
+}
+
+// "iface:NAME": network interface exists and is up
+type ifaceMatch struct {
+	Name  string
+	State *networkState
+}
+
+func (p *mapParser) parseIfaceMatch(name string) (Matcher, error) {
+	if 0 == len(name) {
+		return nil, fmt.Errorf("Missing interface name")
+	}
+	p.m.netState.use()
+	return ifaceMatch{Name: name, State: p.m.netState}, nil
+}
+
+func (m ifaceMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	m.State.mutex.RLock()
+	defer m.State.mutex.RUnlock()
+	return m.State.interfaces[m.Name]
+}
+
+func (m ifaceMatch) String() string {
+	return "iface:" + m.Name
+}
+
+// "local:CIDR": a local address is in the network
+type localMatch struct {
+	CIDR  net.IPNet
+	State *networkState
+}
+
+func (p *mapParser) parseLocalMatch(network string) (Matcher, error) {
+	if _, n, err := net.ParseCIDR(network); nil != err {
+		return nil, fmt.Errorf("Invalid IP network: %q", network)
+	} else {
+		p.m.netState.use()
+		return localMatch{CIDR: normalizeIPNet(*n), State: p.m.netState}, nil
+	}
+}
+
+func (m localMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	m.State.mutex.RLock()
+	defer m.State.mutex.RUnlock()
+	for _, ip := range m.State.addrs {
+		if containsIP(m.CIDR, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m localMatch) String() string {
+	return "local:" + m.CIDR.String()
+}
+
+// "reachable:host:port": a TCP connection to host:port succeeded on the
+// last check
+type reachableMatch struct {
+	Address string
+	State   *networkState
+}
+
+func (p *mapParser) parseReachableMatch(address string) (Matcher, error) {
+	if _, _, err := net.SplitHostPort(address); nil != err {
+		return nil, fmt.Errorf("Invalid address %q: %v", address, err)
+	}
+	p.m.netState.watchReachable(address)
+	return reachableMatch{Address: address, State: p.m.netState}, nil
+}
+
+func (m reachableMatch) Match(ctx context.Context, network string, address AddressDetails) bool {
+	m.State.mutex.RLock()
+	defer m.State.mutex.RUnlock()
+	return m.State.reachable[m.Address]
+}
+
+func (m reachableMatch) String() string {
+	return "reachable:" + m.Address
+}
+
+// conditions don't depend on the request
+func isCondition(m Matcher) bool {
+	switch m := m.(type) {
+	case ifaceMatch, localMatch, reachableMatch:
+		return true
+	case negatedMatch:
+		return isCondition(m.Matcher)
+	case allMatch:
+		for _, part := range m {
+			if !isCondition(part) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package routing
+
+import (
+	"syscall"
+
+	"github.com/rus-cert/socks-router/log"
+)
+
+// multicast groups from linux/rtnetlink.h
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6IfAddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// notify about link, address and route changes (netlink)
+func watchNetwork(changes chan<- struct{}) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_ROUTE)
+	if nil != err {
+		log.Error.Printf("Couldn't watch network changes: %v", err)
+		return
+	}
+	defer syscall.Close(fd)
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv4Route | rtmgrpIPv6IfAddr | rtmgrpIPv6Route,
+	}
+	if err := syscall.Bind(fd, addr); nil != err {
+		log.Error.Printf("Couldn't watch network changes: %v", err)
+		return
+	}
+	buf := make([]byte, 1<<16)
+	for {
+		// ENOBUFS: messages were lost, but still signals a change
+		if _, _, err := syscall.Recvfrom(fd, buf, 0); nil != err && syscall.EINTR != err && syscall.ENOBUFS != err {
+			log.Error.Printf("Couldn't watch network changes: %v", err)
+			return
+		}
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+}
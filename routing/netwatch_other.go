@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package routing
+
+import (
+	"time"
+)
+
+const netStatePollInterval = 5 * time.Second
+
+// no change notifications available: poll
+func watchNetwork(changes chan<- struct{}) {
+	for range time.Tick(netStatePollInterval) {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+}
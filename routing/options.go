@@ -0,0 +1,153 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ResolveMode selects who resolves hostnames of requests
+type ResolveMode int
+
+const (
+	// the target resolves the hostname (e.g. the SOCKS5 proxy)
+	ResolveRemote ResolveMode = iota
+	// resolve locally and connect to the IP address through the target
+	ResolveLocal
+)
+
+// RouteOptions are the "key=value" fields after the target of a route;
+// the zero value are the defaults
+type RouteOptions struct {
+	Timeout time.Duration // dial timeout (0: none)
+	Quiet   bool          // log=off: no access log for the route
+	Resolve ResolveMode
+	Tag     string // shown in logs and statistics
+}
+
+// routes taking options
+type optionsRoute interface {
+	Route
+	options() RouteOptions
+}
+
+func parseRouteOptions(fields []string) (RouteOptions, error) {
+	var o RouteOptions
+	for _, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		if 2 != len(kv) {
+			return o, fmt.Errorf("Invalid route option %q", field)
+		}
+		switch key, value := kv[0], kv[1]; key {
+		case "timeout":
+			if d, err := time.ParseDuration(value); nil != err || d < 0 {
+				return o, fmt.Errorf("Invalid timeout %q", value)
+			} else {
+				o.Timeout = d
+			}
+		case "log":
+			switch value {
+			case "on":
+				o.Quiet = false
+			case "off":
+				o.Quiet = true
+			default:
+				return o, fmt.Errorf("Invalid log option %q (on or off)", value)
+			}
+		case "resolve":
+			switch value {
+			case "remote":
+				o.Resolve = ResolveRemote
+			case "local":
+				o.Resolve = ResolveLocal
+			default:
+				return o, fmt.Errorf("Invalid resolve option %q (remote or local)", value)
+			}
+		case "tag":
+			if 0 == len(value) {
+				return o, fmt.Errorf("Empty tag")
+			}
+			o.Tag = value
+		default:
+			return o, fmt.Errorf("Unknown route option %q", key)
+		}
+	}
+	return o, nil
+}
+
+// options in the syntax of the config file (empty for defaults)
+func (o RouteOptions) String() string {
+	var fields []string
+	if 0 != o.Timeout {
+		fields = append(fields, fmt.Sprintf("timeout=%v", o.Timeout))
+	}
+	if o.Quiet {
+		fields = append(fields, "log=off")
+	}
+	if ResolveLocal == o.Resolve {
+		fields = append(fields, "resolve=local")
+	}
+	if 0 != len(o.Tag) {
+		fields = append(fields, "tag="+o.Tag)
+	}
+	return strings.Join(fields, " ")
+}
+
+// appends the options to a route description
+func withOptions(route string, o RouteOptions) string {
+	if s := o.String(); 0 != len(s) {
+		return route + " " + s
+	}
+	return route
+}
+
+// resolve the hostname of a request (with resolve=local)
+func resolveLocally(ctx context.Context, address *AddressDetails) error {
+	if nil != address.IP {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, address.FQDN)
+	if nil != err {
+		return err
+	} else if 0 == len(addrs) {
+		return fmt.Errorf("No addresses for %v", address.FQDN)
+	}
+	address.setIP(addrs[0].IP, addrs[0].Zone)
+	return nil
+}
+
+// dial with the timeout from the options
+func dialTimeout(ctx context.Context, dialer Dialer, timeout time.Duration, network, address string) (net.Conn, error) {
+	if 0 == timeout {
+		return dialer.Dial(network, address)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if cd, ok := dialer.(ContextDialer); ok {
+		return cd.DialContext(ctx, network, address)
+	}
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := dialer.Dial(network, address)
+		done <- result{conn, err}
+	}()
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+		// close the connection if it gets established anyway
+		go func() {
+			if r := <-done; nil != r.conn {
+				r.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("Timeout connecting to %v", address)
+	}
+}
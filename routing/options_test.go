@@ -0,0 +1,89 @@
+package routing
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestRouteOptions(t *testing.T) {
+	tests := []struct {
+		fields  string
+		options RouteOptions
+		err     string // empty if valid
+	}{
+		{"", RouteOptions{}, ""},
+		{"timeout=5s", RouteOptions{Timeout: 5 * time.Second}, ""},
+		{"timeout=0s", RouteOptions{}, ""},
+		{"log=off", RouteOptions{Quiet: true}, ""},
+		{"log=off log=on", RouteOptions{}, ""},
+		{"resolve=local", RouteOptions{Resolve: ResolveLocal}, ""},
+		{"resolve=remote", RouteOptions{Resolve: ResolveRemote}, ""},
+		{"tag=db", RouteOptions{Tag: "db"}, ""},
+		{"timeout=1m log=off resolve=local tag=db", RouteOptions{Timeout: time.Minute, Quiet: true, Resolve: ResolveLocal, Tag: "db"}, ""},
+		{"timeout=5", RouteOptions{}, `Invalid timeout "5"`},
+		{"timeout=-1s", RouteOptions{}, `Invalid timeout "-1s"`},
+		{"log=quiet", RouteOptions{}, `Invalid log option "quiet"`},
+		{"resolve=dns", RouteOptions{}, `Invalid resolve option "dns"`},
+		{"tag=", RouteOptions{}, "Empty tag"},
+		{"foo=bar", RouteOptions{}, `Unknown route option "foo"`},
+		{"quiet", RouteOptions{}, `Invalid route option "quiet"`},
+	}
+	for _, test := range tests {
+		options, err := parseRouteOptions(strings.Fields(test.fields))
+		if 0 != len(test.err) {
+			if nil == err || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: got error %v, want %v", test.fields, err, test.err)
+			}
+			continue
+		}
+		if nil != err {
+			t.Errorf("%q: %v", test.fields, err)
+		} else if options != test.options {
+			t.Errorf("%q: got %+v, want %+v", test.fields, options, test.options)
+		} else if again, err := parseRouteOptions(strings.Fields(options.String())); nil != err || again != options {
+			t.Errorf("%q: %q doesn't parse to the same options", test.fields, options.String())
+		}
+	}
+}
+
+func TestRouteOptionsLines(t *testing.T) {
+	m, err := ReadMap(strings.NewReader(`.db.example.com socks5://127.0.0.1:9 timeout=5s tag=db # comment
+10.0.0.0/8 direct log=off
+* socks5://127.0.0.1:8
+`))
+	if nil != err {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		address string
+		options RouteOptions
+		route   string
+	}{
+		{"www.db.example.com:5432", RouteOptions{Timeout: 5 * time.Second, Tag: "db"}, ".db.example.com socks5://127.0.0.1:9 timeout=5s tag=db"},
+		{"10.1.2.3:22", RouteOptions{Quiet: true}, "10.0.0.0/8 direct log=off"},
+		{"www.example.com:443", RouteOptions{}, "* socks5://127.0.0.1:8"},
+	}
+	for _, test := range tests {
+		ad, err := ParseAddress(test.address)
+		if nil != err {
+			t.Fatal(err)
+		}
+		d, _ := m.Decide(context.Background(), "tcp", *ad)
+		if d.Options != test.options {
+			t.Errorf("%v: got %+v, want %+v", test.address, d.Options, test.options)
+		}
+		if route := d.Route.(matchRoute).String(); route != test.route {
+			t.Errorf("%v: got %v, want %v", test.address, route, test.route)
+		}
+	}
+
+	// jumps and returns don't take options
+	for _, config := range []string{"* jump x timeout=1s\n@table x\n", "* return log=off\n"} {
+		if _, err := ReadMap(strings.NewReader(config)); nil == err {
+			t.Errorf("%q: options accepted", config)
+		}
+	}
+}
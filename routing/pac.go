@@ -0,0 +1,518 @@
+package routing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"golang.org/x/net/context"
+
+	"github.com/rus-cert/socks-router/log"
+)
+
+const (
+	// per call of FindProxyForURL (including DNS lookups)
+	pacTimeBudget = time.Second
+	// how often to check whether the file changed
+	pacCheckInterval = time.Second
+	// results are cached per host
+	pacCacheTime = time.Minute
+	// interpreters per file (calls beyond that wait)
+	pacRuntimes = 4
+)
+
+// standard PAC functions not needing DNS (see pacScript.newRuntime for
+// the others)
+const pacPrelude = `
+function isPlainHostName(host) {
+	return host.indexOf(".") < 0;
+}
+function dnsDomainIs(host, domain) {
+	return host.length >= domain.length && host.substring(host.length - domain.length) == domain;
+}
+function localHostOrDomainIs(host, hostdom) {
+	return host == hostdom || (host.indexOf(".") < 0 && hostdom.split(".")[0] == host);
+}
+function dnsDomainLevels(host) {
+	return host.split(".").length - 1;
+}
+function shExpMatch(str, pattern) {
+	var re = pattern.replace(/[.+^${}()|[\]\\]/g, "\\$&").replace(/\*/g, ".*").replace(/\?/g, ".");
+	return new RegExp("^" + re + "$").test(str);
+}
+
+var _pacDays = ["SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"];
+var _pacMonths = ["JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"];
+
+// arguments without a trailing "GMT"; gmt tells whether it was present
+function _pacArgs(args) {
+	args = Array.prototype.slice.call(args);
+	var gmt = args.length > 0 && args[args.length - 1] == "GMT";
+	if (gmt) args.pop();
+	return {args: args, gmt: gmt, now: new Date()};
+}
+// first <= value <= last, wrapping around
+function _pacInRange(value, first, last) {
+	return first <= last ? first <= value && value <= last : value >= first || value <= last;
+}
+function weekdayRange() {
+	var a = _pacArgs(arguments);
+	var day = a.gmt ? a.now.getUTCDay() : a.now.getDay();
+	var first = _pacDays.indexOf(a.args[0]);
+	var last = a.args.length > 1 ? _pacDays.indexOf(a.args[1]) : first;
+	return first >= 0 && last >= 0 && _pacInRange(day, first, last);
+}
+// day (1-31), month name and/or year; ranges compare the given fields
+function dateRange() {
+	var a = _pacArgs(arguments);
+	var now = a.gmt
+		? {day: a.now.getUTCDate(), month: a.now.getUTCMonth(), year: a.now.getUTCFullYear()}
+		: {day: a.now.getDate(), month: a.now.getMonth(), year: a.now.getFullYear()};
+	function parse(args) {
+		var d = {};
+		for (var i = 0; i < args.length; i++) {
+			var month = _pacMonths.indexOf(args[i]);
+			if (month >= 0) d.month = month;
+			else if (args[i] > 31) d.year = args[i];
+			else d.day = args[i];
+		}
+		return d;
+	}
+	function key(d, fields) {
+		return ((fields.year !== undefined ? d.year : 0) * 12 + (fields.month !== undefined ? d.month : 0)) * 32 + (fields.day !== undefined ? d.day : 0);
+	}
+	if (a.args.length == 1) {
+		var d = parse(a.args);
+		return key(now, d) == key(d, d);
+	}
+	var first = parse(a.args.slice(0, a.args.length / 2)), last = parse(a.args.slice(a.args.length / 2));
+	return _pacInRange(key(now, first), key(first, first), key(last, first));
+}
+// hour, hour and minute or hour, minute and second
+function timeRange() {
+	var a = _pacArgs(arguments);
+	var now = a.gmt
+		? [a.now.getUTCHours(), a.now.getUTCMinutes(), a.now.getUTCSeconds()]
+		: [a.now.getHours(), a.now.getMinutes(), a.now.getSeconds()];
+	function seconds(t, n) {
+		var s = 0;
+		for (var i = 0; i < 3; i++) s = s * 60 + (i < n ? t[i] : 0);
+		return s;
+	}
+	if (a.args.length == 1) return now[0] == a.args[0];
+	var n = a.args.length / 2;
+	return _pacInRange(seconds(now, n), seconds(a.args.slice(0, n), n), seconds(a.args.slice(n), n));
+}
+`
+
+// "MATCH pac:FILE": FindProxyForURL of a proxy auto-config file decides;
+// DIRECT, SOCKS/SOCKS5 and PROXY/HTTP/HTTPS results are supported, a
+// list ("SOCKS5 a:1080; DIRECT") tries the entries in order.  An empty
+// (or invalid) result continues with the next route.
+type pacRoute struct {
+	Matcher Matcher
+	Script  *pacScript
+	Options RouteOptions
+}
+
+func (p *mapParser) parsePACRoute(matcher Matcher, filename string, options RouteOptions) (Route, error) {
+	script := &pacScript{
+		filename: filename,
+		targets:  newTargetCache(p.m.Targets),
+		slots:    make(chan struct{}, pacRuntimes),
+		results:  make(map[string]*Target),
+		cache:    make(map[string]pacAnswer),
+	}
+	if err := script.load(); nil != err {
+		return nil, err
+	}
+	return pacRoute{Matcher: matcher, Script: script, Options: options}, nil
+}
+
+func (r pacRoute) Match(ctx context.Context, network string, address AddressDetails) *Target {
+	if !r.Matcher.Match(ctx, network, address) {
+		return nil
+	}
+	if target, err := r.Script.route(ctx, address); nil != err {
+		log.Error.Printf("PAC file %q failed for %v: %v", r.Script.filename, address.Address, err)
+		return nil
+	} else {
+		return target
+	}
+}
+
+func (r pacRoute) String() string {
+	return withOptions(fmt.Sprintf("%v pac:%v", r.Matcher, r.Script.filename), r.Options)
+}
+
+func (r pacRoute) options() RouteOptions {
+	return r.Options
+}
+
+func (r pacRoute) specificity() specificity {
+	return specificityOf(r.Matcher)
+}
+
+type pacAnswer struct {
+	target  *Target
+	expires time.Time
+}
+
+// interpreters aren't safe for concurrent use: each call uses one of up
+// to pacRuntimes interpreters running the current version of the file
+type pacScript struct {
+	filename string
+	targets  *targetCache
+	// one entry per interpreter in use
+	slots chan struct{}
+
+	mutex     sync.Mutex
+	program   *goja.Program
+	version   int // incremented on reload
+	idle      []*pacRuntime
+	modTime   time.Time
+	lastCheck time.Time
+	// targets for PAC results
+	results map[string]*Target
+	cache   map[string]pacAnswer
+}
+
+// an interpreter running a version of the file
+type pacRuntime struct {
+	vm      *goja.Runtime
+	fn      goja.Callable
+	version int
+	// context of the current call (for DNS lookups)
+	ctx context.Context
+}
+
+func (s *pacScript) resolve(ctx context.Context, host string) net.IP {
+	if ip := net.ParseIP(host); nil != ip {
+		return ip
+	}
+	resolver, err := getLocalResolver()
+	if nil != err {
+		return nil
+	}
+	ip, err := resolver.Lookup(ctx, host)
+	if nil != err {
+		log.Debug.Printf("PAC file %q: couldn't resolve %q: %v", s.filename, host, err)
+		return nil
+	}
+	return ip
+}
+
+// interpreter with the standard PAC functions running program
+func (s *pacScript) newRuntime(program *goja.Program) (*pacRuntime, error) {
+	vm := goja.New()
+	rt := &pacRuntime{vm: vm, ctx: context.Background()}
+	vm.Set("dnsResolve", func(host string) interface{} {
+		if ip := s.resolve(rt.ctx, host); nil != ip {
+			return ip.String()
+		}
+		return nil
+	})
+	vm.Set("isResolvable", func(host string) bool {
+		return nil != s.resolve(rt.ctx, host)
+	})
+	vm.Set("isInNet", func(host, pattern, mask string) bool {
+		ip, network, netmask := s.resolve(rt.ctx, host), net.ParseIP(pattern), net.ParseIP(mask)
+		if nil == ip || nil == network || nil == netmask {
+			return false
+		}
+		if ipv4 := ip.To4(); nil != ipv4 {
+			ip, network, netmask = ipv4, network.To4(), netmask.To4()
+			if nil == network || nil == netmask {
+				return false
+			}
+		}
+		return ip.Mask(net.IPMask(netmask)).Equal(network.Mask(net.IPMask(netmask)))
+	})
+	vm.Set("myIpAddress", func() string {
+		// no packets are sent for UDP "connections"
+		if conn, err := net.Dial("udp", "192.0.2.1:9"); nil == err {
+			defer conn.Close()
+			return conn.LocalAddr().(*net.UDPAddr).IP.String()
+		}
+		return "127.0.0.1"
+	})
+	vm.Set("alert", func(message string) {
+		log.Info.Printf("PAC file %q: %v", s.filename, message)
+	})
+	if _, err := vm.RunString(pacPrelude); nil != err {
+		return nil, err
+	}
+	if _, err := s.withBudget(vm, func() (goja.Value, error) { return vm.RunProgram(program) }); nil != err {
+		return nil, fmt.Errorf("Couldn't load PAC file %q: %v", s.filename, err)
+	}
+	fn, ok := goja.AssertFunction(vm.Get("FindProxyForURL"))
+	if !ok {
+		return nil, fmt.Errorf("PAC file %q doesn't define FindProxyForURL", s.filename)
+	}
+	rt.fn = fn
+	return rt, nil
+}
+
+// runs fn, interrupting it after the time budget
+func (s *pacScript) withBudget(vm *goja.Runtime, fn func() (goja.Value, error)) (goja.Value, error) {
+	timer := time.AfterFunc(pacTimeBudget, func() {
+		vm.Interrupt("time budget exceeded")
+	})
+	defer func() {
+		timer.Stop()
+		vm.ClearInterrupt()
+	}()
+	return fn()
+}
+
+// compiles the file and runs it in a new interpreter
+func (s *pacScript) compile() (*goja.Program, *pacRuntime, time.Time, error) {
+	fi, err := os.Stat(s.filename)
+	if nil != err {
+		return nil, nil, time.Time{}, err
+	}
+	src, err := ioutil.ReadFile(s.filename)
+	if nil != err {
+		return nil, nil, time.Time{}, err
+	}
+	program, err := goja.Compile(s.filename, string(src), false)
+	if nil != err {
+		return nil, nil, time.Time{}, fmt.Errorf("Couldn't load PAC file %q: %v", s.filename, err)
+	}
+	rt, err := s.newRuntime(program)
+	if nil != err {
+		return nil, nil, time.Time{}, err
+	}
+	return program, rt, fi.ModTime(), nil
+}
+
+// initial load
+func (s *pacScript) load() error {
+	program, rt, modTime, err := s.compile()
+	if nil != err {
+		return err
+	}
+	s.activate(program, rt, modTime)
+	return nil
+}
+
+// needs the lock (or exclusive access); interpreters running older
+// versions are dropped when they are returned
+func (s *pacScript) activate(program *goja.Program, rt *pacRuntime, modTime time.Time) {
+	s.version += 1
+	rt.version = s.version
+	s.program, s.idle, s.modTime = program, []*pacRuntime{rt}, modTime
+	s.cache = make(map[string]pacAnswer)
+}
+
+// reloads the file if it changed (without holding the lock)
+func (s *pacScript) check() {
+	s.mutex.Lock()
+	modTime := s.modTime
+	now := time.Now()
+	check := now.Sub(s.lastCheck) >= pacCheckInterval
+	if check {
+		s.lastCheck = now
+	}
+	s.mutex.Unlock()
+	if !check {
+		return
+	}
+	fi, err := os.Stat(s.filename)
+	if nil != err || fi.ModTime().Equal(modTime) {
+		return
+	}
+	program, rt, newModTime, err := s.compile()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if nil != err {
+		log.Error.Printf("%v (keeping previous version)", err)
+		s.modTime = fi.ModTime()
+	} else {
+		s.activate(program, rt, newModTime)
+		log.Info.Printf("Reloaded PAC file %q", s.filename)
+	}
+}
+
+// an idle interpreter running the current version (or a new one); waits
+// while all are in use
+func (s *pacScript) get(ctx context.Context) (*pacRuntime, error) {
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	s.mutex.Lock()
+	program, version := s.program, s.version
+	for 0 != len(s.idle) {
+		rt := s.idle[len(s.idle)-1]
+		s.idle = s.idle[:len(s.idle)-1]
+		if version == rt.version {
+			s.mutex.Unlock()
+			return rt, nil
+		}
+	}
+	s.mutex.Unlock()
+	rt, err := s.newRuntime(program)
+	if nil != err {
+		<-s.slots
+		return nil, err
+	}
+	rt.version = version
+	return rt, nil
+}
+
+func (s *pacScript) put(rt *pacRuntime) {
+	s.mutex.Lock()
+	if rt.version == s.version {
+		s.idle = append(s.idle, rt)
+	}
+	s.mutex.Unlock()
+	<-s.slots
+}
+
+// URL passed to FindProxyForURL (the path is unknown)
+func pacURL(host, port string) string {
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	switch port {
+	case "443":
+		return "https://" + host + "/"
+	case "80":
+		return "http://" + host + "/"
+	default:
+		return "http://" + host + ":" + port + "/"
+	}
+}
+
+func (s *pacScript) route(ctx context.Context, address AddressDetails) (*Target, error) {
+	host := address.FQDN
+	if nil != address.IP {
+		host = address.IP.String()
+	}
+	if 0 == len(host) {
+		return nil, nil
+	}
+
+	s.check()
+	now := time.Now()
+	s.mutex.Lock()
+	cached, ok := s.cache[host]
+	s.mutex.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.target, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pacTimeBudget)
+	defer cancel()
+	rt, err := s.get(ctx)
+	if nil != err {
+		return nil, err
+	}
+	rt.ctx = ctx
+	result, err := s.withBudget(rt.vm, func() (goja.Value, error) {
+		return rt.fn(goja.Undefined(), rt.vm.ToValue(pacURL(host, address.Port)), rt.vm.ToValue(host))
+	})
+	// values can't be used once the interpreter is returned
+	var answer string
+	if nil == err && !goja.IsNull(result) && !goja.IsUndefined(result) {
+		answer = result.String()
+	}
+	rt.ctx = context.Background()
+	s.put(rt)
+	if nil != err {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	target, err := s.target(answer)
+	if nil != err {
+		return nil, err
+	}
+	for k, a := range s.cache {
+		if now.After(a.expires) {
+			delete(s.cache, k)
+		}
+	}
+	// answers of a replaced version aren't cached
+	if rt.version == s.version {
+		s.cache[host] = pacAnswer{target: target, expires: now.Add(pacCacheTime)}
+	}
+	return target, nil
+}
+
+// PAC result to target; needs the lock
+func (s *pacScript) target(result string) (*Target, error) {
+	result = strings.TrimSpace(result)
+	if 0 == len(result) {
+		return nil, nil
+	} else if target, ok := s.results[result]; ok {
+		return target, nil
+	}
+	var targets []*Target
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if 0 == len(fields) {
+			continue
+		}
+		var name string
+		switch strings.ToUpper(fields[0]) {
+		case "DIRECT":
+			targets = append(targets, &DirectTarget)
+			continue
+		case "SOCKS", "SOCKS5":
+			name = "socks5://"
+		case "PROXY", "HTTP":
+			name = "http://"
+		case "HTTPS":
+			name = "https://"
+		default:
+			return nil, fmt.Errorf("Unsupported result %q", entry)
+		}
+		if 2 != len(fields) {
+			return nil, fmt.Errorf("Invalid result %q", entry)
+		}
+		if target, err := s.targets.target(name + fields[1]); nil != err {
+			return nil, err
+		} else {
+			targets = append(targets, target)
+		}
+	}
+	var target *Target
+	switch len(targets) {
+	case 0:
+		return nil, fmt.Errorf("Invalid result %q", result)
+	case 1:
+		target = targets[0]
+	default:
+		var names []string
+		for _, t := range targets {
+			names = append(names, t.Name)
+		}
+		target = &Target{Name: strings.Join(names, ";"), Dialer: fallbackDialer(targets)}
+	}
+	s.results[result] = target
+	return target, nil
+}
+
+// tries the targets in order until a connection succeeds
+type fallbackDialer []*Target
+
+func (f fallbackDialer) Dial(network, address string) (net.Conn, error) {
+	var err error
+	for _, target := range f {
+		var conn net.Conn
+		if conn, err = target.Dialer.Dial(network, address); nil == err {
+			return conn, nil
+		}
+		log.Debug.Printf("Connection to %v over %v failed: %v", address, target.Name, err)
+	}
+	return nil, err
+}
@@ -0,0 +1,163 @@
+package routing
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+const testPAC = `
+function FindProxyForURL(url, host) {
+	if (host == "direct.example") return "DIRECT";
+	if (host == "socks.example") return "SOCKS 127.0.0.1:1080";
+	if (host == "socks5.example") return "SOCKS5 127.0.0.1:1081";
+	if (host == "proxy.example") return "PROXY 127.0.0.1:3128";
+	if (host == "https.example") return "HTTPS 127.0.0.1:3129";
+	if (host == "list.example") return "SOCKS5 127.0.0.1:1081; DIRECT";
+	if (host == "empty.example") return "";
+	if (host == "null.example") return null;
+	if (host == "invalid.example") return "FTP 127.0.0.1:21";
+	if (host == "loop.example") while (true) {}
+	if (host == "slow.example") { var end = Date.now() + 500; while (Date.now() < end) {} return "DIRECT"; }
+	if (isInNet(host, "10.0.0.0", "255.0.0.0")) return "SOCKS5 10.0.0.1:1080";
+	if (shExpMatch(url, "https://*.corp.example/")) return "PROXY 127.0.0.1:8080";
+	return "SOCKS5 127.0.0.1:9";
+}
+`
+
+func writePAC(t *testing.T, filename, src string) {
+	if err := os.WriteFile(filename, []byte(src), 0644); nil != err {
+		t.Fatal(err)
+	}
+}
+
+func readPACMap(t *testing.T, src string) (*Map, *pacScript) {
+	filename := filepath.Join(t.TempDir(), "proxy.pac")
+	writePAC(t, filename, src)
+	m, err := ReadMap(strings.NewReader("* pac:" + filename + "\n0.0.0.0/0 pac:" + filename + "\n* socks5://127.0.0.1:1\n"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	return m, m.Tables[MainTable].Routes[0].(pacRoute).Script
+}
+
+func TestPACResults(t *testing.T) {
+	m, _ := readPACMap(t, testPAC)
+	tests := []struct {
+		address string
+		route   string
+	}{
+		{"direct.example:80", "direct"},
+		{"socks.example:80", "socks5://127.0.0.1:1080"},
+		{"socks5.example:80", "socks5://127.0.0.1:1081"},
+		{"proxy.example:80", "http://127.0.0.1:3128"},
+		{"https.example:80", "https://127.0.0.1:3129"},
+		{"list.example:80", "socks5://127.0.0.1:1081;direct"},
+		{"10.1.2.3:22", "socks5://10.0.0.1:1080"},
+		{"www.corp.example:443", "http://127.0.0.1:8080"},
+		{"wiki.corp.example:80", "socks5://127.0.0.1:9"},
+		// continue with the next rule
+		{"empty.example:80", "socks5://127.0.0.1:1"},
+		{"null.example:80", "socks5://127.0.0.1:1"},
+		{"invalid.example:80", "socks5://127.0.0.1:1"},
+	}
+	for _, test := range tests {
+		if route := routeFor(m, test.address); route != test.route {
+			t.Errorf("%v: got %v, want %v", test.address, route, test.route)
+		}
+	}
+}
+
+func TestPACFunctions(t *testing.T) {
+	vm := goja.New()
+	if _, err := vm.RunString(pacPrelude); nil != err {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`localHostOrDomainIs("www.example.com", "www.example.com")`, true},
+		{`localHostOrDomainIs("www", "www.example.com")`, true},
+		{`localHostOrDomainIs("www.example", "www.example.com")`, false},
+		{`localHostOrDomainIs("home.example.com", "www.example.com")`, false},
+		{`localHostOrDomainIs("home", "www.example.com")`, false},
+		{`dnsDomainIs("www.example.com", ".example.com")`, true},
+		{`dnsDomainIs("www.example.org", ".example.com")`, false},
+		{`isPlainHostName("www")`, true},
+		{`isPlainHostName("www.example.com")`, false},
+		{`shExpMatch("http://www.example.com/", "*.example.com/")`, true},
+		{`shExpMatch("http://www.example.com/", "*.example.org/")`, false},
+		{`dnsDomainLevels("www.example.com") == 2`, true},
+	}
+	for _, test := range tests {
+		if v, err := vm.RunString(test.expr); nil != err {
+			t.Errorf("%v: %v", test.expr, err)
+		} else if got := v.ToBoolean(); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestPACTimeout(t *testing.T) {
+	m, _ := readPACMap(t, testPAC)
+	start := time.Now()
+	if route := routeFor(m, "loop.example:80"); "socks5://127.0.0.1:1" != route {
+		t.Errorf("got %v, want the next rule", route)
+	}
+	if elapsed := time.Since(start); elapsed > pacTimeBudget+time.Second {
+		t.Errorf("took %v", elapsed)
+	}
+	// the interrupted interpreter is still usable
+	if route := routeFor(m, "direct.example:80"); "direct" != route {
+		t.Errorf("after timeout: %v", route)
+	}
+
+	// calls don't wait for each other
+	done := make(chan string)
+	go func() {
+		done <- routeFor(m, "slow.example:80")
+	}()
+	time.Sleep(100 * time.Millisecond)
+	start = time.Now()
+	if route := routeFor(m, "socks.example:80"); "socks5://127.0.0.1:1080" != route {
+		t.Errorf("concurrent call: %v", route)
+	} else if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Errorf("concurrent call took %v", elapsed)
+	}
+	if route := <-done; "direct" != route {
+		t.Errorf("slow call: %v", route)
+	}
+}
+
+func TestPACReload(t *testing.T) {
+	m, script := readPACMap(t, testPAC)
+	if route := routeFor(m, "direct.example:80"); "direct" != route {
+		t.Fatalf("got %v", route)
+	}
+	modified := func(src string) {
+		writePAC(t, script.filename, src)
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(script.filename, later, later); nil != err {
+			t.Fatal(err)
+		}
+		script.mutex.Lock()
+		script.lastCheck = time.Time{}
+		script.mutex.Unlock()
+	}
+
+	// cached answers are dropped
+	modified(`function FindProxyForURL(url, host) { return "SOCKS5 127.0.0.1:2"; }`)
+	if route := routeFor(m, "direct.example:80"); "socks5://127.0.0.1:2" != route {
+		t.Errorf("after reload: %v", route)
+	}
+	// broken versions are ignored
+	modified(`function FindProxyForURL(url, host) {`)
+	if route := routeFor(m, "direct.example:80"); "socks5://127.0.0.1:2" != route {
+		t.Errorf("after broken reload: %v", route)
+	}
+}
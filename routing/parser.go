@@ -0,0 +1,261 @@
+package routing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// state while reading a Map
+type mapParser struct {
+	m            *Map
+	mostSpecific bool
+	// table new routes are added to
+	table *Table
+	// tables defined with @table (others are only referenced by jumps)
+	defined map[string]bool
+	helpers map[string]*helperPool
+	ipsets  map[string]*ipSet
+	// cached versions are loaded when the whole file was read
+	subscriptions []*subscription
+}
+
+func newMapParser() *mapParser {
+	p := &mapParser{
+		m: &Map{
+			Tables:   make(map[string]*Table),
+			Targets:  make(map[string]*Target),
+			netState: newNetworkState(),
+		},
+		defined: map[string]bool{MainTable: true},
+		helpers: make(map[string]*helperPool),
+		ipsets:  make(map[string]*ipSet),
+	}
+	p.table = p.tableRef(MainTable)
+	return p
+}
+
+// get (or create) table by name
+func (p *mapParser) tableRef(name string) *Table {
+	if table, ok := p.m.Tables[name]; ok {
+		return table
+	}
+	table := &Table{Name: name}
+	p.m.Tables[name] = table
+	return table
+}
+
+func (p *mapParser) parseLine(line string) error {
+	line = strings.TrimSpace(line)
+	if 0 == len(line) || '#' == line[0] {
+		return nil
+	}
+	if '@' == line[0] {
+		// drop trailing comment
+		line = strings.Split(line[1:], "#")[0]
+		return p.parseDirective(strings.Fields(line))
+	}
+	if r, err := p.parseRoute(line); nil != err {
+		return err
+	} else if nil != r {
+		p.table.add(r)
+	}
+	return nil
+}
+
+func (p *mapParser) parseDirective(fields []string) error {
+	if 0 == len(fields) {
+		return fmt.Errorf("Missing directive after '@'")
+	}
+	switch fields[0] {
+	case "target":
+		return p.parseTargetDirective(fields[1:])
+	case "mode":
+		return p.parseModeDirective(fields[1:])
+	case "table":
+		return p.parseTableDirective(fields[1:])
+	case "profile":
+		return p.parseProfileDirective(fields[1:])
+	case "follow-cname":
+		return p.parseFollowCNAMEDirective(fields[1:])
+	case "helper":
+		return p.parseHelperDirective(fields[1:])
+	case "host":
+		return p.parseHostDirective(fields[1:])
+	case "hosts":
+		return p.parseHostsDirective(fields[1:])
+	case "rewrite":
+		return p.parseRewriteDirective(fields[1:])
+	case "ipset":
+		return p.parseIPSetDirective(fields[1:])
+	case "subscribe":
+		return p.parseSubscribeDirective(fields[1:])
+	default:
+		return fmt.Errorf("Unknown directive %q", fields[0])
+	}
+}
+
+// @target NAME TARGET [selectable]
+func (p *mapParser) parseTargetDirective(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("Usage: @target NAME TARGET [selectable]")
+	}
+	name := args[0]
+	if _, err := ParseTarget(name); nil == err || strings.Contains(name, ":") {
+		return fmt.Errorf("Invalid target name %q", name)
+	} else if _, ok := p.m.Targets[name]; ok {
+		return fmt.Errorf("Target %q already defined", name)
+	}
+	target, err := p.parseTarget(args[1])
+	if nil != err {
+		return err
+	}
+	named := *target
+	named.Name = name
+	named.Selectable = false
+	if 3 == len(args) {
+		if "selectable" != args[2] {
+			return fmt.Errorf("Unknown target flag %q", args[2])
+		}
+		named.Selectable = true
+	}
+	p.m.Targets[name] = &named
+	return nil
+}
+
+// @mode first-match|most-specific
+func (p *mapParser) parseModeDirective(args []string) error {
+	if 1 != len(args) {
+		return fmt.Errorf("Usage: @mode first-match|most-specific")
+	}
+	switch args[0] {
+	case "first-match":
+		p.mostSpecific = false
+	case "most-specific":
+		p.mostSpecific = true
+	default:
+		return fmt.Errorf("Unknown mode %q", args[0])
+	}
+	return nil
+}
+
+// @table NAME: following routes are added to the table
+func (p *mapParser) parseTableDirective(args []string) error {
+	if 1 != len(args) {
+		return fmt.Errorf("Usage: @table NAME")
+	}
+	name := args[0]
+	if strings.ContainsAny(name, ":/") {
+		return fmt.Errorf("Invalid table name %q", name)
+	}
+	p.defined[name] = true
+	p.table = p.tableRef(name)
+	return nil
+}
+
+// @profile NAME [CONDITION]: a table which is evaluated before the main
+// table while the profile is active; the first profile is active by
+// default.  Profiles with a condition (on the network state) are
+// activated automatically when the network changes.
+func (p *mapParser) parseProfileDirective(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("Usage: @profile NAME [CONDITION]")
+	}
+	name := args[0]
+	if MainTable == name {
+		return fmt.Errorf("Invalid profile name %q", name)
+	} else if nil == p.m.profiles {
+		p.m.profiles = &profileState{
+			active:     name,
+			conditions: make(map[string]Matcher),
+		}
+	}
+	for _, profile := range p.m.profiles.names {
+		if profile == name {
+			return fmt.Errorf("Profile %q already defined", name)
+		}
+	}
+	if 2 == len(args) {
+		if condition, err := p.parseMatch(args[1]); nil != err {
+			return err
+		} else if !isCondition(condition) {
+			return fmt.Errorf("Not a network condition: %q", args[1])
+		} else {
+			p.m.profiles.conditions[name] = condition
+		}
+	}
+	p.m.profiles.names = append(p.m.profiles.names, name)
+	return p.parseTableDirective(args[:1])
+}
+
+// called after all lines were parsed
+func (p *mapParser) finish() (*Map, error) {
+	for name := range p.m.Tables {
+		if !p.defined[name] {
+			return nil, fmt.Errorf("Jump to undefined table %q", name)
+		}
+	}
+	if err := p.checkLoops(); nil != err {
+		return nil, err
+	}
+	if p.mostSpecific {
+		for _, table := range p.m.Tables {
+			table.sortBySpecificity()
+		}
+	}
+	for _, s := range p.subscriptions {
+		s.load()
+	}
+	p.m.subscriptions = p.subscriptions
+	if profiles := p.m.profiles; nil != profiles && 0 != len(profiles.conditions) {
+		p.m.netState.onChange(profiles.autoSelect)
+	}
+	return p.m, nil
+}
+
+// jumps must not lead back into a table already being evaluated
+func (p *mapParser) checkLoops() error {
+	const (
+		unvisited = iota
+		active
+		done
+	)
+	state := make(map[*Table]int)
+	var visit func(table *Table, path []string) error
+	visit = func(table *Table, path []string) error {
+		path = append(path, table.Name)
+		switch state[table] {
+		case active:
+			return fmt.Errorf("Jump loop: %v", strings.Join(path, " > "))
+		case done:
+			return nil
+		}
+		state[table] = active
+		for _, route := range table.Routes {
+			if jump, ok := route.(jumpRoute); ok {
+				if err := visit(jump.Table, path); nil != err {
+					return err
+				}
+			}
+		}
+		state[table] = done
+		return nil
+	}
+	for _, name := range p.m.tableNames() {
+		if err := visit(p.m.Tables[name], nil); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// named target or target URL
+func (p *mapParser) parseTarget(name string) (*Target, error) {
+	if target, ok := p.m.Targets[name]; ok {
+		return target, nil
+	} else if strings.HasPrefix(name, "split:") {
+		return p.parseSplitTarget(name)
+	} else if strings.HasPrefix(name, "auto:") {
+		return p.parseAutoTarget(name)
+	}
+	return ParseTarget(name)
+}
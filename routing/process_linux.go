@@ -0,0 +1,144 @@
+package routing
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lookupProcess finds the owner of the client socket of a loopback TCP
+// connection through /proc/net/tcp{,6} (uid, inode) and /proc/*/fd
+// (pid).  Finding the pid needs permissions to inspect the process.
+func lookupProcess(client, server *net.TCPAddr) (*ProcessInfo, error) {
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		if uid, inode, err := findSocket(table, client, server); nil != err {
+			return nil, err
+		} else if 0 != inode {
+			process := &ProcessInfo{UID: uid}
+			if pid, err := findSocketOwner(inode); nil == err {
+				process.PID = pid
+				process.Exe, _ = os.Readlink(fmt.Sprintf("/proc/%v/exe", pid))
+			}
+			return process, nil
+		}
+	}
+	return nil, fmt.Errorf("Socket for %v not found", client)
+}
+
+// parse "0100007F:1F90"; the address is stored as 32-bit words in host
+// byte order
+func parseProcNetAddr(s string) (*net.TCPAddr, error) {
+	parts := strings.Split(s, ":")
+	if 2 != len(parts) || 0 != len(parts[0])%8 {
+		return nil, fmt.Errorf("Invalid address %q", s)
+	}
+	ip := make(net.IP, len(parts[0])/2)
+	for i := 0; i < len(ip); i += 4 {
+		if word, err := strconv.ParseUint(parts[0][2*i:2*i+8], 16, 32); nil != err {
+			return nil, fmt.Errorf("Invalid address %q", s)
+		} else {
+			binary.NativeEndian.PutUint32(ip[i:i+4], uint32(word))
+		}
+	}
+	if port, err := strconv.ParseUint(parts[1], 16, 16); nil != err {
+		return nil, fmt.Errorf("Invalid address %q", s)
+	} else {
+		return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+	}
+}
+
+func sameTCPAddr(a, b *net.TCPAddr) bool {
+	return a.Port == b.Port && a.IP.Equal(b.IP)
+}
+
+// returns inode 0 if not found
+func findSocket(table string, local, remote *net.TCPAddr) (uid int, inode uint64, err error) {
+	f, err := os.Open(table)
+	if nil != err {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // skip header
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		if l, err := parseProcNetAddr(fields[1]); nil != err || !sameTCPAddr(l, local) {
+			continue
+		}
+		if r, err := parseProcNetAddr(fields[2]); nil != err || !sameTCPAddr(r, remote) {
+			continue
+		}
+		if uid, err = strconv.Atoi(fields[7]); nil != err {
+			return 0, 0, fmt.Errorf("Invalid uid in %v: %q", table, fields[7])
+		}
+		if inode, err = strconv.ParseUint(fields[9], 10, 64); nil != err {
+			return 0, 0, fmt.Errorf("Invalid inode in %v: %q", table, fields[9])
+		}
+		return uid, inode, nil
+	}
+	return 0, 0, scanner.Err()
+}
+
+func findSocketOwner(inode uint64) (int, error) {
+	link := fmt.Sprintf("socket:[%v]", inode)
+	var pid int
+	found, err := findDirEntry("/proc", func(name string) bool {
+		n, err := strconv.Atoi(name)
+		if nil != err {
+			return false
+		}
+		fdDir := filepath.Join("/proc", name, "fd")
+		// errors: process gone or no permission
+		owner, _ := findDirEntry(fdDir, func(fd string) bool {
+			target, err := os.Readlink(filepath.Join(fdDir, fd))
+			return nil == err && link == target
+		})
+		if owner {
+			pid = n
+		}
+		return owner
+	})
+	if nil != err {
+		return 0, err
+	} else if !found {
+		return 0, fmt.Errorf("No process found for %v", link)
+	}
+	return pid, nil
+}
+
+// reads the names in dir in chunks (without stat'ing the entries) until
+// match returns true
+func findDirEntry(dir string, match func(name string) bool) (bool, error) {
+	d, err := os.Open(dir)
+	if nil != err {
+		return false, err
+	}
+	defer d.Close()
+	for {
+		names, err := d.Readdirnames(64)
+		for _, name := range names {
+			if match(name) {
+				return true, nil
+			}
+		}
+		if io.EOF == err {
+			return false, nil
+		} else if nil != err {
+			return false, err
+		}
+	}
+}
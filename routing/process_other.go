@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package routing
+
+import (
+	"fmt"
+	"net"
+)
+
+func lookupProcess(client, server *net.TCPAddr) (*ProcessInfo, error) {
+	return nil, fmt.Errorf("Looking up client processes not supported on this platform")
+}
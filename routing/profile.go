@@ -0,0 +1,104 @@
+package routing
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/rus-cert/socks-router/log"
+)
+
+// profiles are tables evaluated before the main table; only one
+// (the active profile) is used at a time
+type profileState struct {
+	mutex  sync.Mutex
+	names  []string // in file order
+	active string
+	// optional: activate profile automatically when condition is met
+	conditions map[string]Matcher
+	// results of the conditions on the last check
+	results map[string]bool
+}
+
+// Profiles returns the names of all profiles
+func (m Map) Profiles() []string {
+	if nil == m.profiles {
+		return nil
+	}
+	return m.profiles.names
+}
+
+// ActiveProfile returns the name of the active profile (empty if there
+// are no profiles)
+func (m Map) ActiveProfile() string {
+	if nil == m.profiles {
+		return ""
+	}
+	m.profiles.mutex.Lock()
+	defer m.profiles.mutex.Unlock()
+	return m.profiles.active
+}
+
+// SetProfile changes the active profile
+func (m Map) SetProfile(name string) error {
+	if nil == m.profiles {
+		return fmt.Errorf("No profiles defined")
+	}
+	for _, profile := range m.profiles.names {
+		if profile == name {
+			m.profiles.mutex.Lock()
+			defer m.profiles.mutex.Unlock()
+			m.profiles.active = name
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown profile %q", name)
+}
+
+// activate the first profile (in file order) whose condition is met;
+// only if a condition changed since the last check (keeps profiles
+// selected manually otherwise)
+func (s *profileState) autoSelect() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	results := make(map[string]bool)
+	changed := nil == s.results
+	for name, condition := range s.conditions {
+		results[name] = condition.Match(context.Background(), "", AddressDetails{})
+		if results[name] != s.results[name] {
+			changed = true
+		}
+	}
+	s.results = results
+	if !changed {
+		return
+	}
+	for _, name := range s.names {
+		if condition, ok := s.conditions[name]; ok && results[name] {
+			if name != s.active {
+				log.Info.Printf("switched to profile %q (%v)", name, condition)
+				s.active = name
+			}
+			return
+		}
+	}
+}
+
+// NextProfile activates the profile following the active one (in file
+// order) and returns its name
+func (m Map) NextProfile() (string, error) {
+	if nil == m.profiles || 0 == len(m.profiles.names) {
+		return "", fmt.Errorf("No profiles defined")
+	}
+	m.profiles.mutex.Lock()
+	defer m.profiles.mutex.Unlock()
+	next := m.profiles.names[0]
+	for i, profile := range m.profiles.names {
+		if profile == m.profiles.active && i+1 < len(m.profiles.names) {
+			next = m.profiles.names[i+1]
+		}
+	}
+	m.profiles.active = next
+	return next, nil
+}
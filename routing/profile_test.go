@@ -0,0 +1,60 @@
+package routing
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// condition with a result set by the test
+type testCondition struct {
+	result *bool
+}
+
+func (m testCondition) Match(ctx context.Context, network string, address AddressDetails) bool {
+	return *m.result
+}
+
+func (m testCondition) String() string {
+	return "test"
+}
+
+func TestAutoSelect(t *testing.T) {
+	office, home := false, true
+	m := Map{profiles: &profileState{
+		names:  []string{"office", "home", "mobile"},
+		active: "office",
+		conditions: map[string]Matcher{
+			"office": testCondition{&office},
+			"home":   testCondition{&home},
+		},
+	}}
+	steps := []struct {
+		office, home bool
+		manual       string
+		active       string
+	}{
+		// initial check
+		{false, true, "", "home"},
+		// unchanged conditions keep a manual switch
+		{false, true, "mobile", "mobile"},
+		{false, true, "", "mobile"},
+		{true, true, "", "office"},
+		{true, true, "home", "home"},
+		{true, false, "", "office"},
+		// no condition met anymore: keep the active profile
+		{false, false, "", "office"},
+	}
+	for i, step := range steps {
+		if 0 != len(step.manual) {
+			if err := m.SetProfile(step.manual); nil != err {
+				t.Fatal(err)
+			}
+		}
+		office, home = step.office, step.home
+		m.profiles.autoSelect()
+		if active := m.ActiveProfile(); active != step.active {
+			t.Errorf("step %v: got %v, want %v", i, active, step.active)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package routing
+
+import (
+	"golang.org/x/net/context"
+)
+
+type protocolKey int
+
+// protocolContextKey is the key for inbound protocol values in Contexts.
+// It is unexported; clients use NewProtocolContext and
+// ProtocolFromContext instead of using this key directly.
+const protocolContextKey protocolKey = 0
+
+// NewProtocolContext returns a new Context that carries the name of the
+// protocol the request was received with ("socks4", "socks5", "http",
+// "http-connect", "connect").
+func NewProtocolContext(ctx context.Context, protocol string) context.Context {
+	return context.WithValue(ctx, protocolContextKey, &protocol)
+}
+
+// ProtocolFromContext returns the protocol stored in ctx, if any.
+func ProtocolFromContext(ctx context.Context) (string, bool) {
+	if val := ctx.Value(protocolContextKey); nil == val {
+		return "", false
+	} else if protocol, ok := val.(*string); ok && nil != protocol {
+		return *protocol, true
+	} else {
+		return "", false
+	}
+}
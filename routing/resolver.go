@@ -0,0 +1,23 @@
+package routing
+
+import (
+	"sync"
+
+	"github.com/rus-cert/socks-router/stubresolver"
+)
+
+const resolvConf = "/etc/resolv.conf"
+
+// shared by all users (and reloaded maps) for caching
+var localResolver struct {
+	once     sync.Once
+	resolver *stubresolver.LocalResolver
+	err      error
+}
+
+func getLocalResolver() (*stubresolver.LocalResolver, error) {
+	localResolver.once.Do(func() {
+		localResolver.resolver, localResolver.err = stubresolver.NewLocalResolver(resolvConf)
+	})
+	return localResolver.resolver, localResolver.err
+}
@@ -0,0 +1,162 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/context"
+)
+
+// rewrites can lead to other rewrites; stop after this many
+const maxRewrites = 8
+
+// rewriteRule replaces the destination of requests matching From (a
+// domain or CIDR match) by To: a hostname or IP address replaces the
+// whole host, a network maps the address keeping the host bits.  A port
+// in To replaces the requested port.
+type rewriteRule struct {
+	From Matcher
+	To   Matcher
+}
+
+// @rewrite FROM TO
+func (p *mapParser) parseRewriteDirective(args []string) error {
+	if 2 != len(args) {
+		return fmt.Errorf("Usage: @rewrite FROM TO")
+	}
+	from, err := parseSimpleMatch(args[0])
+	if nil != err {
+		return err
+	}
+	to, err := parseSimpleMatch(args[1])
+	if nil != err {
+		return err
+	}
+	switch to := to.(type) {
+	case domainMatch:
+		if "*" == to.Domain || '.' == to.Domain[0] {
+			return fmt.Errorf("Rewrite target must be a hostname: %q", args[1])
+		}
+	case cidrMatch:
+		if ones, bits := to.CIDR.Mask.Size(); ones != bits {
+			// network mapping
+			if from, ok := from.(cidrMatch); !ok {
+				return fmt.Errorf("Can only map networks to networks: %q", args[1])
+			} else if fromOnes, fromBits := from.CIDR.Mask.Size(); fromOnes != ones || fromBits != bits {
+				return fmt.Errorf("Networks must have the same size: %q and %q", args[0], args[1])
+			}
+		}
+	}
+	rule := rewriteRule{From: from, To: to}
+	if rule.matchesItself() {
+		return fmt.Errorf("Rewrite target %q matches %q again (loop)", args[1], args[0])
+	}
+	p.m.rewrites = append(p.m.rewrites, rule)
+	return nil
+}
+
+// port of a domain or CIDR match ("" for all ports)
+func matchPort(m Matcher) string {
+	switch m := m.(type) {
+	case domainMatch:
+		return m.Port
+	case cidrMatch:
+		return m.Port
+	}
+	return ""
+}
+
+// whether rewritten destinations would match the rule again (a network
+// mapping maps to a network of the same size, so checking its first
+// address is enough)
+func (r rewriteRule) matchesItself() bool {
+	port := matchPort(r.To)
+	if 0 == len(port) {
+		// requested port is kept
+		if port = matchPort(r.From); 0 == len(port) {
+			port = "1"
+		}
+	}
+	var host string
+	switch to := r.To.(type) {
+	case domainMatch:
+		host = to.Domain
+	case cidrMatch:
+		host = to.CIDR.IP.String()
+	default:
+		return false
+	}
+	if address, err := ParseAddress(net.JoinHostPort(host, port)); nil != err {
+		return false
+	} else {
+		return r.matches(address)
+	}
+}
+
+func (r rewriteRule) String() string {
+	return fmt.Sprintf("@rewrite %v %v", r.From, r.To)
+}
+
+// domain rules only apply to requests for hostnames (not to sniffed
+// hostnames)
+func (r rewriteRule) matches(address *AddressDetails) bool {
+	if _, ok := r.From.(domainMatch); ok && nil != address.IP {
+		return false
+	}
+	return r.From.Match(context.Background(), "", *address)
+}
+
+func (r rewriteRule) apply(address *AddressDetails) {
+	if toPort := matchPort(r.To); 0 != len(toPort) {
+		address.Port = toPort
+	}
+
+	switch to := r.To.(type) {
+	case domainMatch:
+		address.FQDN = to.Domain
+		address.IP = nil
+		address.Zone = ""
+		address.Aliases = nil
+		address.Address = net.JoinHostPort(to.Domain, address.Port)
+	case cidrMatch:
+		if ones, bits := to.CIDR.Mask.Size(); ones == bits {
+			// single address: the hostname doesn't apply anymore
+			address.FQDN = ""
+			address.Aliases = nil
+			address.setIP(to.CIDR.IP, "")
+			return
+		}
+		ip := address.IP
+		if 4 == len(to.CIDR.IP) {
+			ip = ip.To4()
+		} else {
+			ip = ip.To16()
+		}
+		mapped := make(net.IP, len(ip))
+		for i := range ip {
+			mapped[i] = to.CIDR.IP[i] | (ip[i] &^ to.CIDR.Mask[i])
+		}
+		address.setIP(mapped, "")
+	}
+}
+
+// applies the first matching rewrite rule until none matches; returns
+// whether the address was rewritten
+func (m Map) rewrite(address *AddressDetails) (bool, error) {
+	for i := 0; i <= maxRewrites; i++ {
+		var rule *rewriteRule
+		for j := range m.rewrites {
+			if m.rewrites[j].matches(address) {
+				rule = &m.rewrites[j]
+				break
+			}
+		}
+		if nil == rule {
+			return 0 != i, nil
+		} else if maxRewrites == i {
+			return true, fmt.Errorf("Too many rewrites for %v (loop?)", address.Address)
+		}
+		rule.apply(address)
+	}
+	return true, nil
+}
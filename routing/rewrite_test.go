@@ -0,0 +1,58 @@
+package routing
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestRewriteLoops(t *testing.T) {
+	tests := []struct {
+		directive string
+		loop      bool
+	}{
+		{"@rewrite 10.0.0.0/8 10.0.0.0/8", true},
+		{"@rewrite 10.0.0.0/8 10.1.2.3", true},
+		{"@rewrite .example.com new.example.com", true},
+		{"@rewrite .example.com:80 new.example.com", true},
+		{"@rewrite * new.example.com:8080", true},
+		{"@rewrite [10.0.0.0/8]:80 [10.0.0.0/8]:8080", false},
+		{"@rewrite 10.1.0.0/16 10.201.0.0/16", false},
+		{"@rewrite .example.com new.example.net", false},
+		{"@rewrite .example.com:80 new.example.com:8080", false},
+		{"@rewrite legacy.example.com new.example.com", false},
+	}
+	for _, test := range tests {
+		_, err := ReadMap(strings.NewReader(test.directive))
+		if loop := nil != err && strings.Contains(err.Error(), "loop"); loop != test.loop {
+			t.Errorf("%v: %v", test.directive, err)
+		}
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	m, err := ReadMap(strings.NewReader(`@rewrite legacy.example.com:80 new.example.com:8080
+@host new.example.com 192.0.2.1
+@rewrite 10.1.0.0/16 10.201.0.0/16
+`))
+	if nil != err {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		address string
+		fqdn    string
+		result  string
+	}{
+		{"legacy.example.com:80", "new.example.com", "192.0.2.1:8080"},
+		{"legacy.example.com:443", "legacy.example.com", "legacy.example.com:443"},
+		{"10.1.2.3:22", "", "10.201.2.3:22"},
+	}
+	for _, test := range tests {
+		if ad, _, err := m.Prepare(context.Background(), test.address); nil != err {
+			t.Errorf("%v: %v", test.address, err)
+		} else if ad.Address != test.result || ad.FQDN != test.fqdn {
+			t.Errorf("%v: got %v (%v), want %v (%v)", test.address, ad.Address, ad.FQDN, test.result, test.fqdn)
+		}
+	}
+}
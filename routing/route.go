@@ -2,153 +2,96 @@ package routing
 
 import (
 	"fmt"
-	"net"
 	"strings"
+
+	"golang.org/x/net/context"
 )
 
 type Route interface {
 	// could theoretically create dynamic targets for each match
-	Match(network string, address AddressDetails) *Target
+	Match(ctx context.Context, network string, address AddressDetails) *Target
 }
 
+// ParseRoute parses a single route line; named targets and directives
+// are only supported through ReadMap
 func ParseRoute(line string) (Route, error) {
 	line = strings.TrimSpace(line)
 	if 0 == len(line) || '#' == line[0] {
 		return nil, nil
 	}
+	return newMapParser().parseRoute(line)
+}
 
-	if '^' != line[0] {
-		// drop trailing comment
-		line = strings.Split(line, "#")[0]
-		fields := strings.Fields(line)
-		if 2 != len(fields) {
-			return nil, fmt.Errorf("Invalid route: %q", line)
+// MATCH TARGET [KEY=VALUE...], MATCH jump TABLE or MATCH return
+func (p *mapParser) parseRoute(line string) (Route, error) {
+	if '^' == line[0] {
+		return nil, fmt.Errorf("Regular expressions not supported yet: %q", line)
+	}
+	// drop trailing comment
+	line = strings.Split(line, "#")[0]
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("Invalid route: %q", line)
+	}
+	matcher, err := p.parseMatch(fields[0])
+	if nil != err {
+		return nil, err
+	}
+	switch fields[1] {
+	case "jump":
+		if 3 != len(fields) {
+			return nil, fmt.Errorf("Usage: MATCH jump TABLE")
 		}
-		if target, err := ParseTarget(fields[1]); nil != err {
-			return nil, err
-		} else {
-			return parseSimpleMatch(fields[0], target)
+		return jumpRoute{
+			Matcher: matcher,
+			Table:   p.tableRef(fields[2]),
+		}, nil
+	case "return":
+		if 2 != len(fields) {
+			return nil, fmt.Errorf("Usage: MATCH return")
 		}
+		return returnRoute{Matcher: matcher}, nil
+	}
+	options, err := parseRouteOptions(fields[2:])
+	if nil != err {
+		return nil, err
+	}
+	if target := fields[1]; strings.HasPrefix(target, "starlark:") {
+		return p.parseStarlarkRoute(matcher, target[9:], options)
+	} else if strings.HasPrefix(target, "helper:") {
+		return p.parseHelperRoute(matcher, target[7:], options)
+	} else if strings.HasPrefix(target, "pac:") {
+		return p.parsePACRoute(matcher, target[4:], options)
+	} else if target, err := p.parseTarget(target); nil != err {
+		return nil, err
 	} else {
-		return nil, fmt.Errorf("Regular expressions not supported yet: %q", line)
+		return matchRoute{
+			Matcher: matcher,
+			Target:  target,
+			Options: options,
+		}, nil
 	}
 }
 
-type cidrRoute struct {
-	CIDR   net.IPNet
-	Port   string
-	Target *Target
+// static target for all requests the Matcher accepts
+type matchRoute struct {
+	Matcher Matcher
+	Target  *Target
+	Options RouteOptions
 }
 
-func (r cidrRoute) Match(network string, address AddressDetails) *Target {
-	if nil != address.IP && r.CIDR.Contains(address.IP) && (0 == len(r.Port) || r.Port == address.Port) {
+func (r matchRoute) Match(ctx context.Context, network string, address AddressDetails) *Target {
+	if r.Matcher.Match(ctx, network, address) {
 		return r.Target
 	} else {
 		return nil
 	}
 }
 
-type domainRoute struct {
-	Domain string
-	Port   string
-	Target *Target
-}
-
-func removeTrailingDot(fqdn string) string {
-	// remove trailing dot
-	if strings.HasSuffix(fqdn, ".") {
-		return fqdn[:len(fqdn)-1]
-	} else {
-		return fqdn
-	}
-}
-
-func (r domainRoute) Match(network string, address AddressDetails) *Target {
-	fqdn := removeTrailingDot(address.FQDN)
-	if 0 != len(fqdn) && (0 == len(r.Port) || r.Port == address.Port) {
-		if "*" == r.Domain {
-			return r.Target
-		} else if '.' == r.Domain[0] {
-			if r.Domain[1:] == fqdn || strings.HasSuffix(fqdn, r.Domain) {
-				return r.Target
-			}
-		} else if r.Domain == fqdn {
-			return r.Target
-		}
-	}
-	return nil
+func (r matchRoute) String() string {
+	return withOptions(fmt.Sprintf("%v %v", r.Matcher, r.Target.Name), r.Options)
 }
 
-func parseSimpleMatch(match string, target *Target) (Route, error) {
-	var network string
-	var host string
-	var port string
-
-	if '[' == match[0] {
-		if rbracket := strings.LastIndex(match, "]"); -1 == rbracket {
-			return nil, fmt.Errorf("Missing closing ']' in %q", match)
-		} else {
-			network = match[1:rbracket]
-			if len(match) > rbracket+1 {
-				if ':' != match[rbracket+1] {
-					return nil, fmt.Errorf("Only ':' allowed after ']' in %q", match)
-				}
-				port = match[rbracket+1:]
-			}
-		}
-	} else if slash := strings.IndexRune(match, '/'); -1 != slash {
-		if lastcolon := strings.LastIndex(match, ":"); lastcolon > slash {
-			network = match[:lastcolon]
-			port = match[lastcolon+1:]
-		} else {
-			network = match
-		}
-	} else if colon := strings.IndexRune(match, ':'); -1 != colon {
-		if lastcolon := strings.LastIndex(match, ":"); lastcolon > colon {
-			// 2 or more colons: always interpret as IPv6 address
-			network = match
-		} else {
-			// single colon: not an IPv6 address, so split port
-			host = match[:colon]
-			port = match[colon+1:]
-		}
-	} else {
-		host = match
-	}
-
-	var ipnet net.IPNet
-	if 0 != len(host) {
-		// cannot be CIDR, but could be single IP address
-		if ipnet.IP = net.ParseIP(host); nil != ipnet.IP {
-			// single host, full mask
-			ipnet.Mask = net.CIDRMask(8*len(ipnet.IP), 8*len(ipnet.IP))
-			network = host
-			host = ""
-		}
-	} else {
-		if ipnet.IP = net.ParseIP(network); nil != ipnet.IP {
-			// single host, full mask
-			ipnet.Mask = net.CIDRMask(8*len(ipnet.IP), 8*len(ipnet.IP))
-		} else if _, n, err := net.ParseCIDR(network); nil != err {
-			return nil, fmt.Errorf("Invalid IP address/network: %q", network)
-		} else {
-			ipnet = *n
-		}
-	}
-
-	if 0 != len(host) {
-		return domainRoute{
-			Domain: removeTrailingDot(host),
-			Port:   port,
-			Target: target,
-		}, nil
-	} else {
-		return cidrRoute{
-			CIDR:   ipnet,
-			Port:   port,
-			Target: target,
-		}, nil
-	}
-
-	return nil, nil
+func (r matchRoute) options() RouteOptions {
+	return r.Options
 }
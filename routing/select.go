@@ -0,0 +1,46 @@
+package routing
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// user names of the form "route=NAME" select the target NAME
+const selectTargetUserPrefix = "route="
+
+type selectKey int
+
+// selectedTargetContextKey is the key for target names selected by the
+// client.  It is unexported; clients use NewUserContext and
+// SelectedTargetFromContext instead of using this key directly.
+const selectedTargetContextKey selectKey = 0
+
+// NewUserContext returns a new Context that carries the target selected
+// by the user name the client sent ("route=NAME"; SOCKS5 username, SOCKS4
+// user id or HTTP Proxy-Authorization).  Other user names are ignored.
+func NewUserContext(ctx context.Context, user string) context.Context {
+	if strings.HasPrefix(user, selectTargetUserPrefix) {
+		return context.WithValue(ctx, selectedTargetContextKey, user[len(selectTargetUserPrefix):])
+	}
+	return ctx
+}
+
+// SelectedTargetFromContext returns the name of the target selected by
+// the client, if any.
+func SelectedTargetFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(selectedTargetContextKey).(string)
+	return name, ok
+}
+
+// target selected by the client, if any
+func (m Map) selectedTarget(ctx context.Context) (*Target, error) {
+	if name, ok := SelectedTargetFromContext(ctx); !ok {
+		return nil, nil
+	} else if target, ok := m.Targets[name]; !ok || !target.Selectable {
+		return nil, fmt.Errorf("Target %q not selectable", name)
+	} else {
+		return target, nil
+	}
+}
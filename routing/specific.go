@@ -0,0 +1,122 @@
+package routing
+
+import (
+	"sort"
+	"strings"
+)
+
+// kinds of matches: prefix lengths and label counts aren't comparable, so
+// the kind is compared first (domain matches win over IP matches, which
+// win over others)
+const (
+	specificOther = iota
+	specificIP
+	specificDomain
+)
+
+// specificity of a match for the most-specific-match mode: higher values
+// (of the same kind) win; port-qualified matches win over portless matches
+// with the same value
+type specificity struct {
+	kind  int
+	value int
+	port  bool
+}
+
+func (s specificity) less(o specificity) bool {
+	if s.kind != o.kind {
+		return s.kind < o.kind
+	}
+	return s.value < o.value || (s.value == o.value && !s.port && o.port)
+}
+
+// implemented by matchers (and routes) supporting the most-specific-match
+// mode; others get the lowest specificity
+type specificMatcher interface {
+	specificity() specificity
+}
+
+func specificityOf(v interface{}) specificity {
+	if s, ok := v.(specificMatcher); ok {
+		return s.specificity()
+	}
+	return specificity{}
+}
+
+// prefix length
+func (m cidrMatch) specificity() specificity {
+	ones, _ := m.CIDR.Mask.Size()
+	return specificity{kind: specificIP, value: ones, port: 0 != len(m.Port)}
+}
+
+// number of labels; exact matches win over subdomain matches with the
+// same suffix
+func (m domainMatch) specificity() specificity {
+	s := specificity{port: 0 != len(m.Port)}
+	if "*" == m.Domain {
+		return s
+	}
+	s.kind = specificDomain
+	if '.' == m.Domain[0] {
+		s.value = 2 * strings.Count(m.Domain, ".")
+	} else {
+		s.value = 2*(strings.Count(m.Domain, ".")+1) + 1
+	}
+	return s
+}
+
+func (m urlMatch) specificity() specificity {
+	return specificityOf(m.Host)
+}
+
+// most specific (not negated) part
+func (m allMatch) specificity() specificity {
+	var s specificity
+	for _, matcher := range m {
+		if ms := specificityOf(matcher); s.less(ms) {
+			s = ms
+		}
+	}
+	return s
+}
+
+func (r matchRoute) specificity() specificity {
+	return specificityOf(r.Matcher)
+}
+
+// jumps, returns and subscriptions stay in place: routes are only sorted
+// between them
+func isOrderBarrier(route Route) bool {
+	switch route.(type) {
+	case jumpRoute, returnRoute, *subscription:
+		return true
+	}
+	return false
+}
+
+// sort routes (with their counters) by specificity, keeping the file
+// order for routes with the same specificity
+func (t *Table) sortBySpecificity() {
+	type entry struct {
+		route   Route
+		counter *routeCounters
+	}
+	entries := make([]entry, len(t.Routes))
+	for i, route := range t.Routes {
+		entries[i] = entry{route, t.counter(i)}
+	}
+	for start, end := 0, 0; start < len(entries); start = end + 1 {
+		for end = start; end < len(entries) && !isOrderBarrier(entries[end].route); end++ {
+		}
+		segment := entries[start:end]
+		sort.SliceStable(segment, func(i, j int) bool {
+			return specificityOf(segment[j].route).less(specificityOf(segment[i].route))
+		})
+	}
+	for i, e := range entries {
+		t.Routes[i] = e.route
+		if i < len(t.counters) {
+			t.counters[i] = e.counter
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package routing
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestMostSpecific(t *testing.T) {
+	m, err := ReadMap(strings.NewReader(`@mode most-specific
+* socks5://127.0.0.1:9
+.example.com socks5://127.0.0.1:1
+host.example.com socks5://127.0.0.1:2
+.host.example.com socks5://127.0.0.1:3
+.example.com:22 socks5://127.0.0.1:4
+10.0.0.0/8 socks5://127.0.0.1:5
+10.1.0.0/16 socks5://127.0.0.1:6
+10.1.2.0/24 socks5://127.0.0.1:7
+`))
+	if nil != err {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr   string
+		fqdn   string
+		target string
+	}{
+		{"a.example.com:80", "", "socks5://127.0.0.1:1"},
+		{"host.example.com:80", "", "socks5://127.0.0.1:2"},
+		{"a.host.example.com:80", "", "socks5://127.0.0.1:3"},
+		{"a.example.com:22", "", "socks5://127.0.0.1:4"},
+		{"example.org:80", "", "socks5://127.0.0.1:9"},
+		{"10.2.2.3:80", "", "socks5://127.0.0.1:5"},
+		{"10.1.3.3:80", "", "socks5://127.0.0.1:6"},
+		{"10.1.2.3:80", "", "socks5://127.0.0.1:7"},
+		// a /24 is not "more specific" than a domain with 3 labels
+		{"10.1.2.3:80", "a.example.com", "socks5://127.0.0.1:1"},
+		{"10.1.2.3:80", "example.org", "socks5://127.0.0.1:7"},
+	}
+	for _, test := range tests {
+		ad, err := ParseAddress(test.addr)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if 0 != len(test.fqdn) {
+			ad.FQDN = test.fqdn
+		}
+		if target := m.Match(context.Background(), "tcp", *ad); nil == target || target.Name != test.target {
+			t.Errorf("%v (%v): got %v, want %v", test.addr, test.fqdn, target, test.target)
+		}
+	}
+}
+
+func TestMostSpecificBarriers(t *testing.T) {
+	m, err := ReadMap(strings.NewReader(`@mode most-specific
+.example.com socks5://127.0.0.1:1
+.skip.example.com return
+host.skip.example.com socks5://127.0.0.1:2
+.example.com jump other
+a.b.c.example.com socks5://127.0.0.1:3
+@table other
+* socks5://127.0.0.1:9
+`))
+	if nil != err {
+		t.Fatal(err)
+	}
+	routes := m.Tables[MainTable].Routes
+	var got []string
+	for _, route := range routes {
+		got = append(got, strings.Fields(fmt.Sprint(route))[1])
+	}
+	want := []string{"socks5://127.0.0.1:1", "return", "socks5://127.0.0.1:2", "jump", "socks5://127.0.0.1:3"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("route order %v, want %v", got, want)
+	}
+}
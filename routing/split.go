@@ -0,0 +1,46 @@
+package routing
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+
+	"github.com/rus-cert/socks-router/log"
+	"github.com/rus-cert/socks-router/stubresolver"
+)
+
+// split:TUNNEL
+func (p *mapParser) parseSplitTarget(name string) (*Target, error) {
+	if _, err := getLocalResolver(); nil != err {
+		return nil, fmt.Errorf("Split horizon target needs local resolver: %v", err)
+	} else if tunnel, err := p.parseTarget(name[6:]); nil != err {
+		return nil, err
+	} else if nil != tunnel.Tunnel {
+		return nil, fmt.Errorf("Invalid tunnel for split horizon target: %q", name)
+	} else {
+		return &Target{
+			Name:   name,
+			Tunnel: tunnel,
+		}, nil
+	}
+}
+
+// replace split horizon target with direct or tunnel target; requests
+// without hostname always use the tunnel
+func splitHorizon(ctx context.Context, address AddressDetails, d *Decision) {
+	tunnel := d.Target.Tunnel
+	resolver, _ := getLocalResolver()
+	if 0 == len(address.FQDN) {
+		d.Split = fmt.Sprintf("%v: no hostname", d.Target.Name)
+		d.Target = tunnel
+	} else if _, err := resolver.Lookup(ctx, address.FQDN); nil == err {
+		d.Split = fmt.Sprintf("%v: %v resolved locally", d.Target.Name, address.FQDN)
+		d.Target = &DirectTarget
+	} else {
+		if stubresolver.ErrNotFound != err {
+			log.Debug.Printf("Local lookup of %v failed: %v", address.FQDN, err)
+		}
+		d.Split = fmt.Sprintf("%v: %v not resolved locally", d.Target.Name, address.FQDN)
+		d.Target = tunnel
+	}
+}
@@ -0,0 +1,198 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"golang.org/x/net/context"
+
+	"github.com/rus-cert/socks-router/log"
+)
+
+const (
+	// per call of the route function
+	starlarkTimeBudget = 100 * time.Millisecond
+	// how often to check whether the script changed
+	starlarkCheckInterval = time.Second
+)
+
+// "MATCH starlark:FILE": the route function of the script decides:
+//
+//	def route(dest, client, proto):
+//	    return "target name" # or None to continue with the next route
+//
+// dest has the fields network, address, fqdn, ip, zone, port and aliases;
+// client is the IP address of the client and proto the inbound protocol
+// (see NewProtocolContext); both can be None.
+type starlarkRoute struct {
+	Matcher Matcher
+	Script  *starlarkScript
+	Options RouteOptions
+}
+
+func (p *mapParser) parseStarlarkRoute(matcher Matcher, filename string, options RouteOptions) (Route, error) {
+	script := &starlarkScript{
+		filename: filename,
+		targets:  newTargetCache(p.m.Targets),
+	}
+	if err := script.load(); nil != err {
+		return nil, err
+	}
+	return starlarkRoute{Matcher: matcher, Script: script, Options: options}, nil
+}
+
+func (r starlarkRoute) Match(ctx context.Context, network string, address AddressDetails) *Target {
+	if !r.Matcher.Match(ctx, network, address) {
+		return nil
+	}
+	if target, err := r.Script.route(ctx, network, address); nil != err {
+		log.Error.Printf("Starlark route %q failed for %v: %v", r.Script.filename, address.Address, err)
+		return nil
+	} else {
+		return target
+	}
+}
+
+func (r starlarkRoute) String() string {
+	return withOptions(fmt.Sprintf("%v starlark:%v", r.Matcher, r.Script.filename), r.Options)
+}
+
+func (r starlarkRoute) options() RouteOptions {
+	return r.Options
+}
+
+func (r starlarkRoute) specificity() specificity {
+	return specificityOf(r.Matcher)
+}
+
+// the script runs without any builtins besides the Starlark core ones;
+// it is reloaded when the file changes
+type starlarkScript struct {
+	filename string
+	targets  *targetCache
+
+	mutex     sync.Mutex
+	fn        starlark.Value
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// runs the top level of the script (with the same time budget as the
+// calls) and returns the route function
+func loadStarlarkScript(filename string) (starlark.Value, error) {
+	thread := &starlark.Thread{
+		Name: filename,
+		Load: func(*starlark.Thread, string) (starlark.StringDict, error) {
+			return nil, fmt.Errorf("load() not supported")
+		},
+	}
+	timer := time.AfterFunc(starlarkTimeBudget, func() {
+		thread.Cancel("time budget exceeded")
+	})
+	globals, err := starlark.ExecFile(thread, filename, nil, nil)
+	timer.Stop()
+	if nil != err {
+		return nil, fmt.Errorf("Couldn't load Starlark script %q: %v", filename, err)
+	}
+	fn, ok := globals["route"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("Starlark script %q doesn't define a route function", filename)
+	}
+	return fn, nil
+}
+
+// initial load
+func (s *starlarkScript) load() error {
+	fi, err := os.Stat(s.filename)
+	if nil != err {
+		return err
+	}
+	fn, err := loadStarlarkScript(s.filename)
+	if nil != err {
+		return err
+	}
+	s.fn, s.modTime = fn, fi.ModTime()
+	return nil
+}
+
+// returns the current route function, reloading the script if needed
+// (without holding the lock)
+func (s *starlarkScript) function() starlark.Value {
+	s.mutex.Lock()
+	fn, modTime := s.fn, s.modTime
+	now := time.Now()
+	check := now.Sub(s.lastCheck) >= starlarkCheckInterval
+	if check {
+		s.lastCheck = now
+	}
+	s.mutex.Unlock()
+	if !check {
+		return fn
+	}
+	fi, err := os.Stat(s.filename)
+	if nil != err || fi.ModTime().Equal(modTime) {
+		return fn
+	}
+	newFn, err := loadStarlarkScript(s.filename)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.modTime = fi.ModTime()
+	if nil != err {
+		log.Error.Printf("%v (keeping previous version)", err)
+	} else {
+		log.Info.Printf("Reloaded Starlark script %q", s.filename)
+		s.fn = newFn
+	}
+	return s.fn
+}
+
+func (s *starlarkScript) route(ctx context.Context, network string, address AddressDetails) (*Target, error) {
+	var ip, client, proto starlark.Value = starlark.None, starlark.None, starlark.None
+	if nil != address.IP {
+		ip = starlark.String(address.IP.String())
+	}
+	if c, ok := ClientFromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(c.Conn.RemoteAddr().String()); nil == err {
+			client = starlark.String(host)
+		}
+	}
+	if protocol, ok := ProtocolFromContext(ctx); ok {
+		proto = starlark.String(protocol)
+	}
+	var aliases []starlark.Value
+	for _, alias := range address.Aliases {
+		aliases = append(aliases, starlark.String(alias))
+	}
+	dest := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"network": starlark.String(network),
+		"address": starlark.String(address.Address),
+		"fqdn":    starlark.String(address.FQDN),
+		"ip":      ip,
+		"zone":    starlark.String(address.Zone),
+		"port":    starlark.String(address.Port),
+		"aliases": starlark.NewList(aliases),
+	})
+
+	thread := &starlark.Thread{Name: s.filename}
+	timer := time.AfterFunc(starlarkTimeBudget, func() {
+		thread.Cancel("time budget exceeded")
+	})
+	result, err := starlark.Call(thread, s.function(), starlark.Tuple{dest, client, proto}, nil)
+	timer.Stop()
+	if nil != err {
+		return nil, err
+	}
+	switch result := result.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.String:
+		return s.targets.target(string(result))
+	default:
+		return nil, fmt.Errorf("route returned %v instead of a target name", result.Type())
+	}
+}
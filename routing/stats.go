@@ -0,0 +1,69 @@
+package routing
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RouteStats is a snapshot of the usage counters of a single route
+type RouteStats struct {
+	Table           string
+	Route           string
+	Matches         uint64
+	SuccessfulDials uint64
+	FailedDials     uint64
+	LastMatch       time.Time // zero if never matched
+}
+
+func (s RouteStats) String() string {
+	lastMatch := "never"
+	if !s.LastMatch.IsZero() {
+		lastMatch = s.LastMatch.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("[%v] %v: %v matches (last: %v), %v successful dials, %v failed dials",
+		s.Table, s.Route, s.Matches, lastMatch, s.SuccessfulDials, s.FailedDials)
+}
+
+type routeCounters struct {
+	mutex        sync.Mutex
+	matches      uint64
+	dials        uint64
+	dialFailures uint64
+	lastMatch    time.Time
+}
+
+func (c *routeCounters) match() {
+	if nil == c {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.matches += 1
+	c.lastMatch = time.Now()
+}
+
+func (c *routeCounters) dial(err error) {
+	if nil == c {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if nil != err {
+		c.dialFailures += 1
+	} else {
+		c.dials += 1
+	}
+}
+
+func (c *routeCounters) snapshot(route Route) RouteStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return RouteStats{
+		Route:           fmt.Sprint(route),
+		Matches:         c.matches,
+		SuccessfulDials: c.dials,
+		FailedDials:     c.dialFailures,
+		LastMatch:       c.lastMatch,
+	}
+}
@@ -0,0 +1,285 @@
+package routing
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/rus-cert/socks-router/log"
+)
+
+// SubscriptionCacheDir stores the last good version of subscribed rules
+// (unless a subscription has its own cache file); no caching if empty
+var SubscriptionCacheDir string
+
+const (
+	defaultSubscriptionInterval = time.Hour
+	subscriptionFetchTimeout    = 30 * time.Second
+	// stored as comments in the cache file
+	etagPrefix         = "# ETag: "
+	lastModifiedPrefix = "# Last-Modified: "
+)
+
+// subscription is a route evaluating the rules downloaded from a URL;
+// the rules are replaced when a new valid version is downloaded
+type subscription struct {
+	URL       string
+	Interval  time.Duration
+	CacheFile string
+	// rules may use named targets and IP sets of the file
+	parser *mapParser
+
+	mutex        sync.RWMutex
+	rules        *Table
+	etag         string
+	lastModified string
+}
+
+// @subscribe URL [interval=DURATION] [cache=FILE]
+func (p *mapParser) parseSubscribeDirective(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("Usage: @subscribe URL [interval=DURATION] [cache=FILE]")
+	}
+	s := &subscription{
+		URL:      args[0],
+		Interval: defaultSubscriptionInterval,
+		parser:   p,
+		rules:    &Table{Name: args[0]},
+	}
+	if !strings.HasPrefix(s.URL, "http://") && !strings.HasPrefix(s.URL, "https://") {
+		return fmt.Errorf("Invalid subscription URL %q", s.URL)
+	}
+	if 0 != len(SubscriptionCacheDir) {
+		hash := sha256.Sum256([]byte(s.URL))
+		s.CacheFile = filepath.Join(SubscriptionCacheDir, "subscription-"+hex.EncodeToString(hash[:8]))
+	}
+	for _, arg := range args[1:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if 2 != len(kv) {
+			return fmt.Errorf("Invalid subscription option %q", arg)
+		}
+		switch kv[0] {
+		case "interval":
+			if d, err := time.ParseDuration(kv[1]); nil != err || d <= 0 {
+				return fmt.Errorf("Invalid interval %q", kv[1])
+			} else {
+				s.Interval = d
+			}
+		case "cache":
+			s.CacheFile = kv[1]
+		default:
+			return fmt.Errorf("Unknown subscription option %q", kv[0])
+		}
+	}
+	p.table.add(s)
+	p.subscriptions = append(p.subscriptions, s)
+	return nil
+}
+
+// download the current version in the background and refresh regularly
+// (see Map.Start)
+func (s *subscription) start() {
+	go func() {
+		s.refresh()
+		for range time.Tick(s.Interval) {
+			s.refresh()
+		}
+	}()
+}
+
+// last good version (if cached); used until the first download succeeded
+func (s *subscription) load() {
+	if err := s.loadCache(); nil != err && !os.IsNotExist(err) {
+		log.Error.Printf("Couldn't load cached subscription %q: %v", s.URL, err)
+	}
+}
+
+func (s *subscription) loadCache() error {
+	if 0 == len(s.CacheFile) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(s.CacheFile)
+	if nil != err {
+		return err
+	}
+	rules, err := s.parse(string(data))
+	if nil != err {
+		return err
+	}
+	var etag, lastModified string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, etagPrefix) {
+			etag = line[len(etagPrefix):]
+		} else if strings.HasPrefix(line, lastModifiedPrefix) {
+			lastModified = line[len(lastModifiedPrefix):]
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rules, s.etag, s.lastModified = rules, etag, lastModified
+	return nil
+}
+
+func (s *subscription) saveCache(body, etag, lastModified string) error {
+	if 0 == len(s.CacheFile) {
+		return nil
+	}
+	var header string
+	if 0 != len(etag) {
+		header += etagPrefix + etag + "\n"
+	}
+	if 0 != len(lastModified) {
+		header += lastModifiedPrefix + lastModified + "\n"
+	}
+	if err := os.MkdirAll(filepath.Dir(s.CacheFile), 0755); nil != err {
+		return err
+	}
+	tmp := s.CacheFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(header+body), 0644); nil != err {
+		return err
+	}
+	return os.Rename(tmp, s.CacheFile)
+}
+
+// targets allowed in subscriptions: downloaded rules must not jump
+// around or run code (starlark:, helper:, pac:)
+func isSubscriptionTarget(target string) bool {
+	switch target {
+	case "jump", "return":
+		return false
+	}
+	for _, prefix := range []string{"starlark:", "helper:", "pac:"} {
+		if strings.HasPrefix(target, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches allowed in subscriptions: no conditions on the local network
+// state (which would need to be watched) and no client processes
+func isSubscriptionMatch(match string) bool {
+	for _, pattern := range strings.Split(match, ",") {
+		typ, _ := splitPatternType(strings.TrimPrefix(pattern, "!"))
+		switch typ {
+		case "iface", "local", "reachable", "uid", "exe":
+			return false
+		}
+	}
+	return true
+}
+
+// the rules of a subscription: plain routes only (MATCH TARGET), no
+// directives
+func (s *subscription) parse(body string) (*Table, error) {
+	table := &Table{Name: s.URL}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	linenum := 0
+	for scanner.Scan() {
+		linenum += 1
+		line := strings.TrimSpace(scanner.Text())
+		if 0 == len(line) || '#' == line[0] {
+			continue
+		} else if '@' == line[0] {
+			return nil, fmt.Errorf("Line %v: directives not supported in subscriptions", linenum)
+		} else if fields := strings.Fields(line); !isSubscriptionMatch(fields[0]) {
+			return nil, fmt.Errorf("Line %v: %q not supported in subscriptions", linenum, fields[0])
+		} else if len(fields) > 1 && !isSubscriptionTarget(fields[1]) {
+			return nil, fmt.Errorf("Line %v: %q not supported in subscriptions", linenum, fields[1])
+		}
+		// parseRoute doesn't modify the parser for other routes
+		route, err := s.parser.parseRoute(line)
+		if nil != err {
+			return nil, fmt.Errorf("Line %v: %v", linenum, err)
+		}
+		table.add(route)
+	}
+	if err := scanner.Err(); nil != err {
+		return nil, err
+	}
+	if s.parser.mostSpecific {
+		table.sortBySpecificity()
+	}
+	return table, nil
+}
+
+// conditional download; invalid versions are ignored
+func (s *subscription) refresh() {
+	if err := s.fetch(); nil != err {
+		log.Error.Printf("Couldn't update subscription %q: %v", s.URL, err)
+	}
+}
+
+func (s *subscription) fetch() error {
+	req, err := http.NewRequest("GET", s.URL, nil)
+	if nil != err {
+		return err
+	}
+	s.mutex.RLock()
+	if 0 != len(s.etag) {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	if 0 != len(s.lastModified) {
+		req.Header.Set("If-Modified-Since", s.lastModified)
+	}
+	s.mutex.RUnlock()
+	client := http.Client{Timeout: subscriptionFetchTimeout}
+	resp, err := client.Do(req)
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	if http.StatusNotModified == resp.StatusCode {
+		log.Debug.Printf("Subscription %q not modified", s.URL)
+		return nil
+	} else if http.StatusOK != resp.StatusCode {
+		return fmt.Errorf("HTTP status %v", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if nil != err {
+		return err
+	}
+	body := string(data)
+	rules, err := s.parse(body)
+	if nil != err {
+		return fmt.Errorf("Invalid rules (keeping previous version): %v", err)
+	}
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	s.mutex.Lock()
+	s.rules, s.etag, s.lastModified = rules, etag, lastModified
+	s.mutex.Unlock()
+	log.Info.Printf("Updated subscription %q (%v rules)", s.URL, len(rules.Routes))
+	if err := s.saveCache(body, etag, lastModified); nil != err {
+		log.Error.Printf("Couldn't cache subscription %q: %v", s.URL, err)
+	}
+	return nil
+}
+
+func (s *subscription) table() *Table {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.rules
+}
+
+func (s *subscription) Match(ctx context.Context, network string, address AddressDetails) *Target {
+	var d Decision
+	if s.table().decide(ctx, network, address, &d) {
+		return d.Target
+	}
+	return nil
+}
+
+func (s *subscription) String() string {
+	return "@subscribe " + s.URL
+}
@@ -0,0 +1,216 @@
+package routing
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// serves rules with an ETag; counts (conditional) requests
+type rulesServer struct {
+	mutex    sync.Mutex
+	body     string
+	etag     string
+	requests int
+	notMod   int
+}
+
+func (s *rulesServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests++
+	if r.Header.Get("If-None-Match") == s.etag {
+		s.notMod++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", s.etag)
+	w.Write([]byte(s.body))
+}
+
+func (s *rulesServer) set(body, etag string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.body, s.etag = body, etag
+}
+
+func (s *rulesServer) counts() (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests, s.notMod
+}
+
+func routeFor(m *Map, address string) string {
+	ad, err := ParseAddress(address)
+	if nil != err {
+		return err.Error()
+	}
+	d, _ := m.Decide(context.Background(), "tcp", *ad)
+	return d.TargetName()
+}
+
+func TestSubscription(t *testing.T) {
+	rules := &rulesServer{}
+	rules.set(".corp.example.com vpn\n10.0.0.0/8 vpn\n", `"v1"`)
+	server := httptest.NewServer(rules)
+	defer server.Close()
+	SubscriptionCacheDir = t.TempDir()
+	defer func() { SubscriptionCacheDir = "" }()
+
+	config := `@target vpn socks5://127.0.0.1:2080
+.public.corp.example.com direct
+@subscribe ` + server.URL + `/rules
+* socks5://127.0.0.1:1080
+`
+	m, err := ReadMap(strings.NewReader(config))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if requests, _ := rules.counts(); 0 != requests {
+		t.Fatalf("%v requests before Start", requests)
+	}
+	if route := routeFor(m, "www.corp.example.com:80"); "socks5://127.0.0.1:1080" != route {
+		t.Errorf("before download: %v", route)
+	}
+
+	m.Start()
+	s := m.subscriptions[0]
+	// stored in the cache after the rules were replaced
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(s.CacheFile); nil == err {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("subscription not downloaded")
+		}
+	}
+	tests := []struct {
+		address string
+		route   string
+	}{
+		{"www.corp.example.com:80", "vpn"},
+		{"www.public.corp.example.com:80", "direct"},
+		{"10.1.2.3:22", "vpn"},
+		{"www.example.org:443", "socks5://127.0.0.1:1080"},
+	}
+	for _, test := range tests {
+		if route := routeFor(m, test.address); route != test.route {
+			t.Errorf("%v: got %v, want %v", test.address, route, test.route)
+		}
+	}
+
+	// counted when dialing
+	ad, _ := ParseAddress("www.corp.example.com:80")
+	d, _ := m.Decide(context.Background(), "tcp", *ad)
+	d.countMatch()
+	var matches uint64
+	for _, stats := range m.Stats() {
+		if s.URL == stats.Table && ".corp.example.com vpn" == stats.Route {
+			matches = stats.Matches
+		}
+	}
+	if 1 != matches {
+		t.Errorf("subscribed rule stats: %v matches", matches)
+	}
+
+	if err := s.fetch(); nil != err {
+		t.Fatal(err)
+	} else if _, notMod := rules.counts(); 1 != notMod {
+		t.Error("no conditional request")
+	}
+	// invalid versions are ignored
+	rules.set(".corp.example.com starlark:rules.star\n", `"v2"`)
+	if err := s.fetch(); nil == err {
+		t.Error("invalid rules accepted")
+	}
+	if route := routeFor(m, "www.corp.example.com:80"); "vpn" != route {
+		t.Errorf("after invalid update: %v", route)
+	}
+
+	// offline: the cached version is loaded synchronously
+	server.Close()
+	m, err = ReadMap(strings.NewReader(config))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if route := routeFor(m, "www.corp.example.com:80"); "vpn" != route {
+		t.Errorf("from cache: %v", route)
+	}
+}
+
+func TestSubscriptionContent(t *testing.T) {
+	p := newMapParser()
+	p.m.Targets["vpn"] = &Target{Name: "vpn"}
+	s := &subscription{URL: "http://rules.example/", parser: p}
+	tests := []struct {
+		body  string
+		valid bool
+	}{
+		{".corp.example.com vpn\n# comment\n\n10.0.0.0/8 direct log=off\n", true},
+		{"* socks5://127.0.0.1:1080\n", true},
+		{"@target x direct\n", false},
+		{"* jump other\n", false},
+		{".example.com return\n", false},
+		{"* starlark:/etc/rules.star\n", false},
+		{"* helper:policy\n", false},
+		{"* pac:/etc/proxy.pac\n", false},
+		{"* unknown-target\n", false},
+		{"iface:tun0 vpn\n", false},
+		{"!local:192.168.0.0/16 vpn\n", false},
+		{"reachable:10.0.0.1:80 vpn\n", false},
+		{".corp.example.com,uid:1000 direct\n", false},
+		{"exe:/usr/bin/curl direct\n", false},
+		// single-label hostname with a port
+		{"local:80 direct\n", true},
+	}
+	for _, test := range tests {
+		if _, err := s.parse(test.body); (nil == err) != test.valid {
+			t.Errorf("%q: %v", test.body, err)
+		}
+	}
+	if p.m.netState.isUsed() {
+		t.Error("rejected condition watched")
+	}
+}
+
+func TestSubscriptionMostSpecific(t *testing.T) {
+	cache := t.TempDir() + "/rules"
+	if err := ioutil.WriteFile(cache, []byte(".corp.example.com vpn\n10.0.0.0/8 vpn\n"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	m, err := ReadMap(strings.NewReader(`@mode most-specific
+@target vpn socks5://127.0.0.1:2080
+10.1.0.0/16 direct
+www.corp.example.com direct
+@subscribe http://rules.example/ cache=` + cache + `
+* socks5://127.0.0.1:1080
+db.corp.example.com socks5://127.0.0.1:1081
+.example.com socks5://127.0.0.1:1082
+`))
+	if nil != err {
+		t.Fatal(err)
+	}
+	// @subscribe is an order barrier
+	tests := []struct {
+		address string
+		route   string
+	}{
+		{"www.corp.example.com:80", "direct"},
+		{"10.1.2.3:80", "direct"},
+		{"10.2.0.1:80", "vpn"},
+		{"db.corp.example.com:80", "vpn"},
+		{"www.example.com:80", "socks5://127.0.0.1:1082"},
+		{"www.example.org:80", "socks5://127.0.0.1:1080"},
+	}
+	for _, test := range tests {
+		if route := routeFor(m, test.address); route != test.route {
+			t.Errorf("%v: got %v, want %v", test.address, route, test.route)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package routing
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// name of the table evaluation starts with
+const MainTable = "main"
+
+// Table is a list of routes; first match wins (in most-specific-match
+// mode the routes are sorted accordingly when reading the Map)
+type Table struct {
+	Name   string
+	Routes []Route
+	// usage counters for Routes (same index); optional
+	counters []*routeCounters
+}
+
+func (t *Table) counter(index int) *routeCounters {
+	if index < len(t.counters) {
+		return t.counters[index]
+	}
+	return nil
+}
+
+func (t *Table) add(route Route) {
+	t.Routes = append(t.Routes, route)
+	t.counters = append(t.counters, &routeCounters{})
+}
+
+func (t *Table) appendStats(stats []RouteStats, label string) []RouteStats {
+	for i, route := range t.Routes {
+		var s RouteStats
+		if c := t.counter(i); nil != c {
+			s = c.snapshot(route)
+		} else {
+			s = RouteStats{Route: fmt.Sprint(route)}
+		}
+		s.Table = label
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// evaluates the table, recording the path in d; returns true when a
+// target was found
+func (t *Table) decide(ctx context.Context, network string, address AddressDetails, d *Decision) bool {
+	d.Tables = append(d.Tables, t.Name)
+	for i, route := range t.Routes {
+		switch r := route.(type) {
+		case jumpRoute:
+			if r.Matcher.Match(ctx, network, address) {
+				d.counters = append(d.counters, t.counter(i))
+				if r.Table.decide(ctx, network, address, d) {
+					return true
+				}
+				// back in this table
+				d.Tables = append(d.Tables, t.Name)
+			}
+		case returnRoute:
+			if r.Matcher.Match(ctx, network, address) {
+				d.counters = append(d.counters, t.counter(i))
+				return false
+			}
+		case *subscription:
+			if r.table().decide(ctx, network, address, d) {
+				d.counters = append(d.counters, t.counter(i))
+				return true
+			}
+			d.Tables = append(d.Tables, t.Name)
+		default:
+			if target := route.Match(ctx, network, address); nil != target {
+				d.Target = target
+				d.Route = route
+				if r, ok := route.(optionsRoute); ok {
+					d.Options = r.options()
+				}
+				d.counter = t.counter(i)
+				d.counters = append(d.counters, d.counter)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// "MATCH jump TABLE": evaluate another table; continue with the next
+// route if it doesn't find a target
+type jumpRoute struct {
+	Matcher Matcher
+	Table   *Table
+}
+
+func (r jumpRoute) Match(ctx context.Context, network string, address AddressDetails) *Target {
+	var d Decision
+	if r.Matcher.Match(ctx, network, address) && r.Table.decide(ctx, network, address, &d) {
+		return d.Target
+	}
+	return nil
+}
+
+func (r jumpRoute) String() string {
+	return fmt.Sprintf("%v jump %v", r.Matcher, r.Table.Name)
+}
+
+// "MATCH return": stop evaluating the current table
+type returnRoute struct {
+	Matcher Matcher
+}
+
+// only handled in Table.decide
+func (r returnRoute) Match(ctx context.Context, network string, address AddressDetails) *Target {
+	return nil
+}
+
+func (r returnRoute) String() string {
+	return fmt.Sprintf("%v return", r.Matcher)
+}
+
+// Decision describes how a request is routed
+type Decision struct {
+	Target   *Target      // nil if no route matched: connect directly
+	Route    Route        // matching route; nil if none matched or selected
+	Selected bool         // target selected by the client
+	Profile  string       // active profile (if any)
+	Tables   []string     // tables evaluated (in order)
+	Split    string       // why a split horizon target went direct or over the tunnel
+	Options  RouteOptions // of the matching route
+	// counters of the matching route and of the jump / return routes
+	counter  *routeCounters
+	counters []*routeCounters
+}
+
+// count matches of all routes involved
+func (d Decision) countMatch() {
+	for _, c := range d.counters {
+		c.match()
+	}
+}
+
+// TargetName returns the name of the target used
+func (d Decision) TargetName() string {
+	if nil == d.Target {
+		return DirectTarget.Name
+	}
+	return d.Target.Name
+}
+
+func (d Decision) dialer() Dialer {
+	if nil == d.Target {
+		return DirectTarget.Dialer
+	}
+	return d.Target.Dialer
+}
+
+// describes the route for log messages
+func (d Decision) String() string {
+	var s string
+	if d.Selected {
+		s = fmt.Sprintf("over %v (selected by client)", d.Target.Name)
+	} else if nil == d.Target {
+		s = "directly"
+	} else {
+		s = fmt.Sprintf("over %v", d.Target.Name)
+	}
+	if 0 != len(d.Split) {
+		s += fmt.Sprintf(" (%v)", d.Split)
+	}
+	if len(d.Tables) > 1 {
+		s += fmt.Sprintf(" (tables %v)", strings.Join(d.Tables, " > "))
+	}
+	if 0 != len(d.Profile) {
+		s += fmt.Sprintf(" [profile %v]", d.Profile)
+	}
+	if 0 != len(d.Options.Tag) {
+		s += fmt.Sprintf(" [tag %v]", d.Options.Tag)
+	}
+	return s
+}
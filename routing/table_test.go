@@ -0,0 +1,87 @@
+package routing
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestJumpReturn(t *testing.T) {
+	m, err := ReadMap(strings.NewReader(`.example.com jump web
+10.0.0.0/8 jump internal
+* socks5://127.0.0.1:9
+
+@table web
+.skip.example.com return
+.corp.example.com jump internal
+*:443 socks5://127.0.0.1:1
+
+@table internal
+.public.corp.example.com return
+10.0.0.0/8 socks5://127.0.0.1:2
+* socks5://127.0.0.1:2
+`))
+	if nil != err {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		address string
+		target  string
+		tables  string
+	}{
+		{"www.example.com:443", "socks5://127.0.0.1:1", "main web"},
+		// no match in the table: continue after the jump
+		{"www.example.com:80", "socks5://127.0.0.1:9", "main web main"},
+		{"www.skip.example.com:443", "socks5://127.0.0.1:9", "main web main"},
+		{"www.corp.example.com:80", "socks5://127.0.0.1:2", "main web internal"},
+		// return only leaves the innermost table
+		{"www.public.corp.example.com:443", "socks5://127.0.0.1:1", "main web internal web"},
+		{"10.1.2.3:22", "socks5://127.0.0.1:2", "main internal"},
+		// "*" only matches hostnames
+		{"192.0.2.1:22", "direct", "main"},
+	}
+	for _, test := range tests {
+		ad, err := ParseAddress(test.address)
+		if nil != err {
+			t.Fatal(err)
+		}
+		d, err := m.Decide(context.Background(), "tcp", *ad)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if target := d.TargetName(); target != test.target {
+			t.Errorf("%v: got %v, want %v", test.address, target, test.target)
+		}
+		if tables := strings.Join(d.Tables, " "); tables != test.tables {
+			t.Errorf("%v: got tables %v, want %v", test.address, tables, test.tables)
+		}
+	}
+}
+
+func TestJumpLoops(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string // empty if valid
+	}{
+		{"* jump a\n@table a\n* jump b\n@table b\n* direct\n", ""},
+		// several jumps to the same table aren't a loop
+		{"* jump a\n* jump b\n@table a\n* jump b\n@table b\n* direct\n", ""},
+		{"* jump main\n", "Jump loop: main > main"},
+		{"* jump a\n@table a\n* jump a\n", "Jump loop: main > a > a"},
+		{"* jump a\n@table a\n* jump b\n@table b\n.example.com jump a\n", "Jump loop: main > a > b > a"},
+		// tables not reachable from main are checked, too
+		{"@table a\n* jump b\n@table b\n* jump a\n", "Jump loop: a > b > a"},
+		{"* jump missing\n", "missing"},
+	}
+	for _, test := range tests {
+		_, err := ReadMap(strings.NewReader(test.config))
+		if 0 == len(test.err) {
+			if nil != err {
+				t.Errorf("%q: %v", test.config, err)
+			}
+		} else if nil == err || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: got %v, want %v", test.config, err, test.err)
+		}
+	}
+}
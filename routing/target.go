@@ -3,6 +3,7 @@ package routing
 import (
 	"fmt"
 	"net"
+	"strings"
 
 	"golang.org/x/net/proxy"
 )
@@ -10,6 +11,11 @@ import (
 type Target struct {
 	Name   string
 	Dialer Dialer
+	// clients may select the target explicitly (see NewUserContext)
+	Selectable bool
+	// split horizon target (no Dialer): connect directly if the local
+	// resolver knows the hostname, otherwise over Tunnel
+	Tunnel *Target
 }
 
 var DirectTarget = Target{
@@ -23,7 +29,7 @@ var DirectTarget = Target{
 func ParseTarget(name string) (*Target, error) {
 	if "direct" == name {
 		return &DirectTarget, nil
-	} else if "socks5://" == name[0:9] {
+	} else if strings.HasPrefix(name, "socks5://") {
 		if dial, err := proxy.SOCKS5("tcp", name[9:], nil /* auth */, proxy.Direct); nil != err {
 			return nil, fmt.Errorf("Invalid target: %v", err)
 		} else {
@@ -32,6 +38,8 @@ func ParseTarget(name string) (*Target, error) {
 				Dialer: dial,
 			}, nil
 		}
+	} else if strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+		return parseHTTPConnectTarget(name)
 	} else {
 		return nil, fmt.Errorf("Invalid target: %q", name)
 	}
@@ -0,0 +1,39 @@
+package routing
+
+import (
+	"sync"
+)
+
+// looks up target names returned by scripts and helpers at runtime
+type targetCache struct {
+	// named targets of the Map
+	targets map[string]*Target
+
+	mutex sync.Mutex
+	// targets parsed from other names
+	parsed map[string]*Target
+}
+
+func newTargetCache(targets map[string]*Target) *targetCache {
+	return &targetCache{
+		targets: targets,
+		parsed:  make(map[string]*Target),
+	}
+}
+
+// named target or target URL
+func (c *targetCache) target(name string) (*Target, error) {
+	if target, ok := c.targets[name]; ok {
+		return target, nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if target, ok := c.parsed[name]; ok {
+		return target, nil
+	} else if target, err := ParseTarget(name); nil != err {
+		return nil, err
+	} else {
+		c.parsed[name] = target
+		return target, nil
+	}
+}
@@ -0,0 +1,55 @@
+package sniff
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+)
+
+var errNotHTTP = errors.New("Not a HTTP/1.x request")
+var errNoHost = errors.New("No Host header in HTTP request")
+
+// HTTPHost extracts the hostname (without port) from the Host header of
+// a HTTP/1.x request.  Returns errIncomplete if more data is needed.
+func HTTPHost(data []byte) (string, error) {
+	eol := bytes.IndexByte(data, '\n')
+	if -1 == eol {
+		if len(data) > 0 && !isTokenChar(data[0]) {
+			return "", errNotHTTP
+		}
+		return "", errIncomplete
+	}
+	// request line: METHOD SP request-target SP HTTP/1.x
+	fields := strings.Split(strings.TrimRight(string(data[:eol]), "\r"), " ")
+	if 3 != len(fields) || !strings.HasPrefix(fields[2], "HTTP/1.") {
+		return "", errNotHTTP
+	}
+	data = data[eol+1:]
+	for {
+		eol := bytes.IndexByte(data, '\n')
+		if -1 == eol {
+			return "", errIncomplete
+		}
+		line := strings.TrimRight(string(data[:eol]), "\r")
+		data = data[eol+1:]
+		if 0 == len(line) {
+			// end of header
+			return "", errNoHost
+		}
+		if colon := strings.IndexByte(line, ':'); -1 != colon && strings.EqualFold("Host", line[:colon]) {
+			host := strings.TrimSpace(line[colon+1:])
+			if h, _, err := net.SplitHostPort(host); nil == err {
+				host = h
+			}
+			if 0 == len(host) {
+				return "", errNoHost
+			}
+			return host, nil
+		}
+	}
+}
+
+func isTokenChar(c byte) bool {
+	return c > ' ' && c < 0x7f
+}
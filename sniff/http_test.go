@@ -0,0 +1,29 @@
+package sniff
+
+import (
+	"testing"
+)
+
+func TestHTTPHost(t *testing.T) {
+	tests := []struct {
+		data string
+		host string
+		err  error
+	}{
+		{"GET / HTTP/1.1\r\nHost: www.example.com\r\n\r\n", "www.example.com", nil},
+		{"GET / HTTP/1.1\r\nAccept: */*\r\nhost:  example.com:8080 \r\n\r\n", "example.com", nil},
+		{"GET / HTTP/1.0\nHost: [2001:db8::1]:80\n\n", "2001:db8::1", nil},
+		{"GET / HTTP/1.1\r\nHost: www.exa", "", errIncomplete},
+		{"GET / HT", "", errIncomplete},
+		{"GET / HTTP/1.1\r\nAccept: */*\r\n\r\nHost: example.com\r\n", "", errNoHost},
+		{"GET / HTTP/1.1\r\nHost: \r\n\r\n", "", errNoHost},
+		{"GET / HTTP/2.0\r\nHost: example.com\r\n\r\n", "", errNotHTTP},
+		{"SSH-2.0-OpenSSH_9.6\r\n", "", errNotHTTP},
+		{"\x16\x03\x01", "", errNotHTTP},
+	}
+	for _, test := range tests {
+		if host, err := HTTPHost([]byte(test.data)); host != test.host || err != test.err {
+			t.Errorf("HTTPHost(%q) = %q, %v; want %q, %v", test.data, host, err, test.host, test.err)
+		}
+	}
+}
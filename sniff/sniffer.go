@@ -0,0 +1,111 @@
+package sniff
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/rus-cert/socks-router/log"
+	"github.com/rus-cert/socks-router/routing"
+	"github.com/rus-cert/socks-router/stubresolver"
+)
+
+const defaultTimeout = time.Second
+
+// DefaultPorts are sniffed if Sniffer.Ports is empty
+var DefaultPorts = []string{"80", "443"}
+
+// Sniffer looks at the first data a client sends through a tunnel to
+// find the hostname for requests using only an IP address.  The tunnel
+// needs to be acknowledged to the client before dialing, so connection
+// errors can't be reported through the proxy protocol anymore.
+type Sniffer struct {
+	// should use the hostname stored in the context (see
+	// stubresolver.NewFqdnContext) to route the connection
+	Dialer  routing.ContextDialer
+	Timeout time.Duration // how long to wait for client data
+	// destination ports to sniff (DefaultPorts if empty); clients of
+	// protocols where the server speaks first would otherwise be delayed
+	Ports []string
+}
+
+// Applies returns whether requests to addr should be sniffed
+func (s *Sniffer) Applies(addr string) bool {
+	if host, port, err := net.SplitHostPort(addr); nil != err {
+		return false
+	} else if nil == net.ParseIP(host) {
+		return false
+	} else {
+		ports := s.Ports
+		if 0 == len(ports) {
+			ports = DefaultPorts
+		}
+		for _, p := range ports {
+			if p == port {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Dial waits for the first data the client sends (reading from r, with
+// the deadline set on conn), extracts the hostname (TLS SNI; HTTP Host
+// header on port 80) and dials addr routing by the hostname.  The returned reader replays the consumed
+// data and continues reading from r.
+func (s *Sniffer) Dial(ctx context.Context, conn net.Conn, r io.Reader, network, addr string) (net.Conn, io.Reader, error) {
+	_, port, _ := net.SplitHostPort(addr)
+	peek, name := s.sniff(conn, r, port)
+	if 0 != len(name) {
+		log.Debug.Printf("sniffed hostname %q for %v", name, addr)
+		ctx = stubresolver.NewFqdnContext(ctx, name)
+	}
+	if backend, err := s.Dialer.DialContext(ctx, network, addr); nil != err {
+		return nil, nil, err
+	} else {
+		return backend, io.MultiReader(bytes.NewReader(peek), r), nil
+	}
+}
+
+// try the protocols expected on the destination port
+func sniffName(data []byte, port string) (string, error) {
+	name, err := TLSServerName(data)
+	if errNotTLS == err && "80" == port {
+		return HTTPHost(data)
+	}
+	return name, err
+}
+
+func (s *Sniffer) sniff(conn net.Conn, r io.Reader, port string) ([]byte, string) {
+	timeout := s.Timeout
+	if 0 == timeout {
+		timeout = defaultTimeout
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 0, 2048)
+	for {
+		if len(buf) == cap(buf) {
+			if len(buf) >= maxClientHelloLen {
+				return buf, ""
+			}
+			tmp := make([]byte, len(buf), 2*cap(buf))
+			copy(tmp, buf)
+			buf = tmp
+		}
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if name, perr := sniffName(buf, port); nil == perr {
+			return buf, name
+		} else if errIncomplete != perr {
+			return buf, ""
+		}
+		if nil != err {
+			return buf, ""
+		}
+	}
+}
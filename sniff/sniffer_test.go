@@ -0,0 +1,28 @@
+package sniff
+
+import (
+	"testing"
+)
+
+func TestSnifferApplies(t *testing.T) {
+	tests := []struct {
+		ports   []string
+		addr    string
+		applies bool
+	}{
+		{nil, "192.0.2.1:443", true},
+		{nil, "192.0.2.1:80", true},
+		{nil, "[2001:db8::1]:443", true},
+		{nil, "192.0.2.1:22", false},
+		{nil, "example.com:443", false},
+		{nil, "192.0.2.1", false},
+		{[]string{"8443"}, "192.0.2.1:8443", true},
+		{[]string{"8443"}, "192.0.2.1:443", false},
+	}
+	for _, test := range tests {
+		s := &Sniffer{Ports: test.ports}
+		if applies := s.Applies(test.addr); applies != test.applies {
+			t.Errorf("Applies(%q) with ports %v = %v", test.addr, test.ports, applies)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package sniff
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errIncomplete = errors.New("Incomplete data")
+var errNotTLS = errors.New("Not a TLS ClientHello")
+var errNoServerName = errors.New("No server name in TLS ClientHello")
+
+// limit for the reassembled ClientHello handshake message (and sniffed
+// data in general)
+const maxClientHelloLen = 1 << 16
+
+// TLSServerName extracts the server name (SNI) from a TLS ClientHello,
+// which might be fragmented over several records.  Returns errIncomplete
+// if more data is needed.
+func TLSServerName(data []byte) (string, error) {
+	var handshake []byte
+	for {
+		if len(data) < 5 {
+			return "", errIncomplete
+		}
+		// record: type (handshake = 22), version, length
+		if 22 != data[0] || 3 != data[1] {
+			return "", errNotTLS
+		}
+		recordLen := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < 5+recordLen {
+			return "", errIncomplete
+		}
+		handshake = append(handshake, data[5:5+recordLen]...)
+		data = data[5+recordLen:]
+
+		if len(handshake) < 4 {
+			continue
+		}
+		// handshake: type (client_hello = 1), 24-bit length
+		if 1 != handshake[0] {
+			return "", errNotTLS
+		}
+		helloLen := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+		if helloLen > maxClientHelloLen {
+			return "", errNotTLS
+		}
+		if len(handshake) >= 4+helloLen {
+			return clientHelloServerName(handshake[4 : 4+helloLen])
+		}
+	}
+}
+
+// reads length-prefixed byte strings
+type reader []byte
+
+func (r *reader) skip(n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*r = (*r)[n:]
+	return true
+}
+
+func (r *reader) readVector(lenBytes int) (reader, bool) {
+	if len(*r) < lenBytes {
+		return nil, false
+	}
+	var l int
+	for _, b := range (*r)[:lenBytes] {
+		l = l<<8 | int(b)
+	}
+	if len(*r) < lenBytes+l {
+		return nil, false
+	}
+	v := (*r)[lenBytes : lenBytes+l]
+	*r = (*r)[lenBytes+l:]
+	return v, true
+}
+
+func (r *reader) readUint16() (uint16, bool) {
+	if len(*r) < 2 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint16(*r)
+	*r = (*r)[2:]
+	return v, true
+}
+
+func clientHelloServerName(hello reader) (string, error) {
+	// client_version, random
+	if !hello.skip(2 + 32) {
+		return "", errNotTLS
+	}
+	// session_id, cipher_suites, compression_methods
+	if _, ok := hello.readVector(1); !ok {
+		return "", errNotTLS
+	}
+	if _, ok := hello.readVector(2); !ok {
+		return "", errNotTLS
+	}
+	if _, ok := hello.readVector(1); !ok {
+		return "", errNotTLS
+	}
+	extensions, ok := hello.readVector(2)
+	if !ok {
+		return "", errNoServerName
+	}
+	for len(extensions) > 0 {
+		extType, ok := extensions.readUint16()
+		if !ok {
+			return "", errNotTLS
+		}
+		extData, ok := extensions.readVector(2)
+		if !ok {
+			return "", errNotTLS
+		}
+		if 0 != extType {
+			continue
+		}
+		// server_name extension
+		names, ok := extData.readVector(2)
+		if !ok {
+			return "", errNotTLS
+		}
+		for len(names) > 0 {
+			nameType := names[0]
+			names.skip(1)
+			name, ok := names.readVector(2)
+			if !ok {
+				return "", errNotTLS
+			}
+			if 0 == nameType && len(name) > 0 {
+				return string(name), nil
+			}
+		}
+	}
+	return "", errNoServerName
+}
@@ -0,0 +1,60 @@
+package sniff
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+// first flight of a TLS client
+func clientHello(t *testing.T, serverName string) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+		client.Close()
+	}()
+	buf := make([]byte, maxClientHelloLen)
+	n, err := server.Read(buf)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+// splits the handshake message of a single record into two records
+func fragment(data []byte, at int) []byte {
+	body := data[5:]
+	var out []byte
+	for _, part := range [][]byte{body[:at], body[at:]} {
+		out = append(out, data[0], data[1], data[2], byte(len(part)>>8), byte(len(part)))
+		out = append(out, part...)
+	}
+	return out
+}
+
+func TestTLSServerName(t *testing.T) {
+	hello := clientHello(t, "www.example.com")
+	tests := []struct {
+		name string
+		data []byte
+		host string
+		err  error
+	}{
+		{"complete", hello, "www.example.com", nil},
+		{"fragmented", fragment(hello, 10), "www.example.com", nil},
+		{"fragmented header", fragment(hello, 2), "www.example.com", nil},
+		{"record header only", hello[:3], "", errIncomplete},
+		{"truncated", hello[:len(hello)-1], "", errIncomplete},
+		{"first fragment", fragment(hello, 10)[:15], "", errIncomplete},
+		{"no SNI", clientHello(t, "192.0.2.1"), "", errNoServerName},
+		{"http", []byte("GET / HTTP/1.1\r\n"), "", errNotTLS},
+		{"alert record", []byte{21, 3, 1, 0, 2, 2, 40}, "", errNotTLS},
+		{"server hello", []byte{22, 3, 3, 0, 4, 2, 0, 0, 0}, "", errNotTLS},
+	}
+	for _, test := range tests {
+		if host, err := TLSServerName(test.data); host != test.host || err != test.err {
+			t.Errorf("%v: got %q, %v; want %q, %v", test.name, host, err, test.host, test.err)
+		}
+	}
+}
@@ -3,6 +3,12 @@ package socks
 import (
 	"io"
 	"net"
+
+	"golang.org/x/net/context"
+
+	"github.com/rus-cert/socks-router/httpproxy"
+	"github.com/rus-cert/socks-router/routing"
+	"github.com/rus-cert/socks-router/sniff"
 )
 
 type SocksError int
@@ -24,25 +30,39 @@ func (e SocksError) Error() string {
 }
 
 type Dialer interface {
-	// Dial connects to the given address via the proxy.
-	Dial(network, addr string) (c net.Conn, err error)
+	// DialContext connects to the given address via the proxy; ctx
+	// carries the client connection (see routing.NewClientContext)
+	DialContext(ctx context.Context, network, addr string) (c net.Conn, err error)
 }
 
 type Server struct {
 	Dialer Dialer
+	// optional: sniff hostname for requests with IP addresses
+	Sniffer *sniff.Sniffer
+}
+
+// the request was already granted; connect and forward afterwards
+func (s Server) forwardSniffed(ctx context.Context, conn net.Conn, addr string) error {
+	if backend, r, err := s.Sniffer.Dial(ctx, conn, conn, "tcp", addr); nil != err {
+		return err
+	} else {
+		defer backend.Close()
+		return httpproxy.Forward(conn, r, backend, backend)
+	}
 }
 
 func (s Server) ServeConn(conn net.Conn) error {
 	defer conn.Close()
+	ctx := routing.NewClientContext(context.Background(), conn)
 	var hdr [1]byte
 	if _, err := io.ReadFull(conn, hdr[:]); nil != err {
 		return err
 	}
 	switch hdr[0] {
 	case 0x04:
-		return s.serverConnSocks4(conn)
+		return s.serverConnSocks4(routing.NewProtocolContext(ctx, "socks4"), conn)
 	case 0x05:
-		return s.serverConnSocks5(conn)
+		return s.serverConnSocks5(routing.NewProtocolContext(ctx, "socks5"), conn)
 	default:
 		return ErrInvalidVersion
 	}
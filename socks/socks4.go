@@ -7,7 +7,10 @@ import (
 	"net"
 	"strconv"
 
+	"golang.org/x/net/context"
+
 	"github.com/rus-cert/socks-router/httpproxy"
+	"github.com/rus-cert/socks-router/routing"
 )
 
 type socks4ResultCode byte
@@ -59,7 +62,7 @@ func sendSocks4Reply(conn net.Conn, code socks4ResultCode) error {
 	return err
 }
 
-func (s Server) serverConnSocks4(conn net.Conn) error {
+func (s Server) serverConnSocks4(ctx context.Context, conn net.Conn) error {
 	var hdr [7]byte
 	if _, err := io.ReadFull(conn, hdr[:]); nil != err {
 		return err
@@ -75,9 +78,11 @@ func (s Server) serverConnSocks4(conn net.Conn) error {
 	}
 
 	buf := make([]byte, 0, 512)
-	if _ /* username */, err := readZeroTerminatedString(&buf, conn, 128); nil != err {
+	if user, err := readZeroTerminatedString(&buf, conn, 128); nil != err {
 		sendSocks4Reply(conn, errCode4Rejected)
 		return err
+	} else {
+		ctx = routing.NewUserContext(ctx, string(user))
 	}
 	port := binary.BigEndian.Uint16(hdr[1:3])
 	var addr string
@@ -95,7 +100,15 @@ func (s Server) serverConnSocks4(conn net.Conn) error {
 		addr = ip.String()
 	}
 
-	if backend, err := s.Dialer.Dial("tcp", net.JoinHostPort(addr, strconv.Itoa(int(port)))); nil != err {
+	dest := net.JoinHostPort(addr, strconv.Itoa(int(port)))
+	if nil != s.Sniffer && s.Sniffer.Applies(dest) {
+		if err := sendSocks4Reply(conn, errCode4Granted); nil != err {
+			return err
+		}
+		return s.forwardSniffed(ctx, conn, dest)
+	}
+
+	if backend, err := s.Dialer.DialContext(ctx, "tcp", dest); nil != err {
 		sendSocks4Reply(conn, errCode4Rejected)
 		return err
 	} else {
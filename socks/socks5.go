@@ -8,7 +8,10 @@ import (
 	"strconv"
 	"strings"
 
+	"golang.org/x/net/context"
+
 	"github.com/rus-cert/socks-router/httpproxy"
+	"github.com/rus-cert/socks-router/routing"
 )
 
 type socks5ResultCode byte
@@ -68,7 +71,33 @@ func socks5MapDialError(err error) socks5ResultCode {
 	return errCode5HostUnreachable
 }
 
-func (s Server) serverConnSocks5(conn net.Conn) error {
+// RFC 1929 username/password sub-negotiation; returns username
+func readSocks5UserPass(conn net.Conn) (string, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); nil != err {
+		return "", err
+	} else if 0x01 != hdr[0] {
+		conn.Write([]byte{0x01, 0x01}) // failure
+		return "", ErrInvalidVersion
+	}
+	user := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, user); nil != err {
+		return "", err
+	}
+	var passLen [1]byte
+	if _, err := io.ReadFull(conn, passLen[:]); nil != err {
+		return "", err
+	}
+	if _, err := io.ReadFull(conn, make([]byte, passLen[0])); nil != err {
+		return "", err
+	}
+	if _, err := conn.Write([]byte{0x01, 0x00}); nil != err { // success
+		return "", err
+	}
+	return string(user), nil
+}
+
+func (s Server) serverConnSocks5(ctx context.Context, conn net.Conn) error {
 	{
 		var methods []byte
 		var nmethods [1]byte
@@ -81,7 +110,16 @@ func (s Server) serverConnSocks5(conn net.Conn) error {
 			}
 		}
 
-		if -1 == bytes.IndexByte(methods, 0) {
+		if -1 != bytes.IndexByte(methods, 2) {
+			// username is only used to select a route; any password
+			// is accepted
+			conn.Write([]byte{0x05, 0x02}) // select "username/password"
+			if user, err := readSocks5UserPass(conn); nil != err {
+				return err
+			} else {
+				ctx = routing.NewUserContext(ctx, user)
+			}
+		} else if -1 == bytes.IndexByte(methods, 0) {
 			// client doesn't support "no authentication"
 			conn.Write([]byte{0x05, 0xff}) // no acceptable method
 			return nil
@@ -146,7 +184,15 @@ func (s Server) serverConnSocks5(conn net.Conn) error {
 		return sendSocks5Error(conn, errCode5AddressTypeNotSupported)
 	}
 
-	if backend, err := s.Dialer.Dial("tcp", net.JoinHostPort(addr, strconv.Itoa(int(port)))); nil != err {
+	dest := net.JoinHostPort(addr, strconv.Itoa(int(port)))
+	if nil != s.Sniffer && s.Sniffer.Applies(dest) {
+		if err := sendSocks5ReplyAddr(conn, errCode5Succeeded, nil, 0); nil != err {
+			return err
+		}
+		return s.forwardSniffed(ctx, conn, dest)
+	}
+
+	if backend, err := s.Dialer.DialContext(ctx, "tcp", dest); nil != err {
 		return sendSocks5Error(conn, socks5MapDialError(err))
 	} else {
 		defer backend.Close()
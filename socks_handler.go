@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/rus-cert/socks-router/sniff"
 	"github.com/rus-cert/socks-router/socks"
 )
 
@@ -17,10 +18,11 @@ func (h socksHandler) Detect(peek []byte) (ConnHandler, error) {
 }
 
 // CreateSocksHandler returns a ProtocolHandler to detect and handle
-// SOCKS[4,4a,5] requests
-func CreateSocksHandler(dialer Dialer) (ProtocolHandler, error) {
+// SOCKS[4,4a,5] requests; sniffer is optional
+func CreateSocksHandler(dialer Dialer, sniffer *sniff.Sniffer) (ProtocolHandler, error) {
 	server := socks.Server{
-		Dialer: dialer,
+		Dialer:  dialer,
+		Sniffer: sniffer,
 	}
 
 	return socksHandler{server}, nil
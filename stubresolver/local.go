@@ -0,0 +1,243 @@
+package stubresolver
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// ErrNotFound is returned if a name doesn't exist (NXDOMAIN) or has no
+// addresses
+var ErrNotFound = errors.New("Name not found")
+
+// name exists, but not with the queried type
+var errNoData = errors.New("No data")
+
+const (
+	minCacheTTL = 5 * time.Second
+	maxCacheTTL = time.Hour
+	// for negative answers without SOA record
+	defaultNegativeTTL = time.Minute
+	// failures (timeouts, SERVFAIL) are cached briefly so requests don't
+	// pile up waiting for broken nameservers
+	failureCacheTTL = 5 * time.Second
+	// expired entries are removed when the cache grows beyond this
+	cacheCleanupSize = 4096
+)
+
+type cacheEntry struct {
+	ip      net.IP
+	names   []string
+	err     error
+	expires time.Time
+}
+
+/* queries the nameservers from resolv.conf directly (to get the TTLs);
+ * answers (including negative ones) and CNAME chains are cached per name
+ */
+type LocalResolver struct {
+	Config *dns.ClientConfig
+
+	mutex  sync.Mutex
+	cache  map[string]cacheEntry
+	cnames map[string]cacheEntry
+}
+
+func NewLocalResolver(resolvconf string) (*LocalResolver, error) {
+	if config, err := dns.ClientConfigFromFile(resolvconf); nil != err {
+		return nil, err
+	} else if 0 == len(config.Servers) {
+		return nil, errors.New("No nameservers in " + resolvconf)
+	} else {
+		return &LocalResolver{
+			Config: config,
+			cache:  make(map[string]cacheEntry),
+			cnames: make(map[string]cacheEntry),
+		}, nil
+	}
+}
+
+func (r *LocalResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	ip, err := r.Lookup(ctx, name)
+	return ctx, ip, err
+}
+
+// Lookup returns the first address of the name (IPv4 preferred), or
+// ErrNotFound; other errors (no nameserver reachable) are only cached
+// briefly
+func (r *LocalResolver) Lookup(ctx context.Context, name string) (net.IP, error) {
+	name = dns.Fqdn(name)
+	now := time.Now()
+	r.mutex.Lock()
+	entry, ok := r.cache[name]
+	r.mutex.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.ip, entry.err
+	}
+
+	ip, ttl, err := r.query(ctx, name, dns.TypeA)
+	if errNoData == err {
+		ip, ttl, err = r.query(ctx, name, dns.TypeAAAA)
+	}
+	if errNoData == err {
+		err = ErrNotFound
+	}
+	if nil != err && ErrNotFound != err {
+		if nil != ctx.Err() {
+			// request gone: not the nameservers' fault
+			return nil, err
+		}
+		ttl = failureCacheTTL
+	} else if ttl < minCacheTTL {
+		ttl = minCacheTTL
+	} else if ttl > maxCacheTTL {
+		ttl = maxCacheTTL
+	}
+	r.store(r.cache, name, cacheEntry{ip: ip, err: err, expires: now.Add(ttl)})
+	return ip, err
+}
+
+// add an entry to the cache, removing expired entries when it grows too
+// large
+func (r *LocalResolver) store(cache map[string]cacheEntry, name string, entry cacheEntry) {
+	now := time.Now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(cache) >= cacheCleanupSize {
+		for n, e := range cache {
+			if now.After(e.expires) {
+				delete(cache, n)
+			}
+		}
+	}
+	cache[name] = entry
+}
+
+// ask the nameservers in order until one gives a definite answer
+// (success or NXDOMAIN)
+func (r *LocalResolver) exchange(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	client := dns.Client{Timeout: time.Duration(r.Config.Timeout) * time.Second}
+	var err error
+	for _, server := range r.Config.Servers {
+		server = net.JoinHostPort(server, r.Config.Port)
+		var resp *dns.Msg
+		if resp, _, err = client.ExchangeContext(ctx, msg, server); nil == err && resp.Truncated {
+			tcp := client
+			tcp.Net = "tcp"
+			resp, _, err = tcp.ExchangeContext(ctx, msg, server)
+		}
+		if nil != err {
+			if nil != ctx.Err() {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		switch resp.Rcode {
+		case dns.RcodeSuccess, dns.RcodeNameError:
+			return resp, nil
+		default:
+			err = errors.New("Nameserver " + server + " failed: " + dns.RcodeToString[resp.Rcode])
+		}
+	}
+	return nil, err
+}
+
+func (r *LocalResolver) query(ctx context.Context, name string, qtype uint16) (net.IP, time.Duration, error) {
+	if resp, err := r.exchange(ctx, name, qtype); nil != err {
+		return nil, 0, err
+	} else if dns.RcodeNameError == resp.Rcode {
+		return nil, negativeTTL(resp), ErrNotFound
+	} else if ip, ttl := answerAddress(resp, qtype); nil != ip {
+		return ip, ttl, nil
+	} else {
+		return nil, negativeTTL(resp), errNoData
+	}
+}
+
+// CNAMEs returns the names the CNAME chain of name leads to (in order,
+// without name itself and without trailing dots); chains are cached for
+// their TTL, failures briefly
+func (r *LocalResolver) CNAMEs(ctx context.Context, name string) ([]string, error) {
+	name = dns.Fqdn(name)
+	now := time.Now()
+	r.mutex.Lock()
+	entry, ok := r.cnames[name]
+	r.mutex.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.names, entry.err
+	}
+
+	resp, err := r.exchange(ctx, name, dns.TypeA)
+	if nil != err {
+		if nil == ctx.Err() {
+			r.store(r.cnames, name, cacheEntry{err: err, expires: now.Add(failureCacheTTL)})
+		}
+		return nil, err
+	}
+	targets := make(map[string]string)
+	ttl := maxCacheTTL
+	for _, rr := range resp.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			targets[strings.ToLower(cname.Hdr.Name)] = strings.ToLower(cname.Target)
+			if t := time.Duration(cname.Hdr.Ttl) * time.Second; t < ttl {
+				ttl = t
+			}
+		}
+	}
+	if 0 == len(targets) {
+		ttl = negativeTTL(resp)
+	}
+	var names []string
+	for next, ok := targets[strings.ToLower(name)]; ok && len(names) < len(targets); next, ok = targets[next] {
+		names = append(names, strings.TrimSuffix(next, "."))
+	}
+	if ttl < minCacheTTL {
+		ttl = minCacheTTL
+	}
+
+	r.store(r.cnames, name, cacheEntry{names: names, expires: now.Add(ttl)})
+	return names, nil
+}
+
+// first address in the answer; TTL is the minimum along the CNAME chain
+func answerAddress(resp *dns.Msg, qtype uint16) (net.IP, time.Duration) {
+	var ip net.IP
+	ttl := maxCacheTTL
+	for _, rr := range resp.Answer {
+		if t := time.Duration(rr.Header().Ttl) * time.Second; t < ttl {
+			ttl = t
+		}
+		switch a := rr.(type) {
+		case *dns.A:
+			if nil == ip && dns.TypeA == qtype {
+				ip = a.A
+			}
+		case *dns.AAAA:
+			if nil == ip && dns.TypeAAAA == qtype {
+				ip = a.AAAA
+			}
+		}
+	}
+	return ip, ttl
+}
+
+// RFC 2308: minimum of SOA TTL and SOA MINIMUM field
+func negativeTTL(resp *dns.Msg) time.Duration {
+	for _, rr := range resp.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := soa.Hdr.Ttl
+			if soa.Minttl < ttl {
+				ttl = soa.Minttl
+			}
+			return time.Duration(ttl) * time.Second
+		}
+	}
+	return defaultNegativeTTL
+}
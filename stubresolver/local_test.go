@@ -0,0 +1,163 @@
+package stubresolver
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/context"
+)
+
+// stand-in nameserver: answers from records, SERVFAIL for names in
+// failing, NXDOMAIN (with SOA) for everything else
+type testServer struct {
+	records map[string][]string
+	failing map[string]bool
+
+	mutex   sync.Mutex
+	queries map[string]int
+}
+
+func (s *testServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	q := req.Question[0]
+	s.mutex.Lock()
+	s.queries[q.Name]++
+	s.mutex.Unlock()
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	if s.failing[q.Name] {
+		resp.Rcode = dns.RcodeServerFailure
+	} else if records, ok := s.records[q.Name]; ok {
+		for _, record := range records {
+			rr, _ := dns.NewRR(record)
+			if rr.Header().Rrtype == q.Qtype || dns.TypeCNAME == rr.Header().Rrtype {
+				resp.Answer = append(resp.Answer, rr)
+			}
+		}
+	} else {
+		resp.Rcode = dns.RcodeNameError
+		soa, _ := dns.NewRR("example. 600 IN SOA ns.example. hostmaster.example. 1 3600 600 86400 30")
+		resp.Ns = append(resp.Ns, soa)
+	}
+	w.WriteMsg(resp)
+}
+
+func (s *testServer) count(name string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.queries[name]
+}
+
+// resolver using a stand-in nameserver
+func newTestResolver(t *testing.T, s *testServer) *LocalResolver {
+	s.queries = make(map[string]int)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if nil != err {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: s, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+
+	host, port, _ := net.SplitHostPort(pc.LocalAddr().String())
+	return &LocalResolver{
+		Config: &dns.ClientConfig{Servers: []string{host}, Port: port, Timeout: 1, Attempts: 1},
+		cache:  make(map[string]cacheEntry),
+		cnames: make(map[string]cacheEntry),
+	}
+}
+
+func TestLookup(t *testing.T) {
+	s := &testServer{
+		records: map[string][]string{
+			"www.example.": {"www.example. 300 IN A 192.0.2.1"},
+			"v6.example.":  {"v6.example. 300 IN AAAA 2001:db8::1"},
+			"txt.example.": {"txt.example. 300 IN TXT \"no address\""},
+		},
+		failing: map[string]bool{"broken.example.": true},
+	}
+	r := newTestResolver(t, s)
+	tests := []struct {
+		name string
+		ip   string
+		err  string // part of the error message
+		ttl  time.Duration
+	}{
+		{"www.example", "192.0.2.1", "", 300 * time.Second},
+		{"v6.example", "2001:db8::1", "", 300 * time.Second},
+		{"txt.example", "", ErrNotFound.Error(), defaultNegativeTTL},
+		{"missing.example", "", ErrNotFound.Error(), 30 * time.Second},
+		{"broken.example", "", "SERVFAIL", failureCacheTTL},
+	}
+	for _, test := range tests {
+		for i := 0; i < 2; i++ {
+			ip, err := r.Lookup(context.Background(), test.name)
+			if 0 == len(test.err) {
+				if nil != err || !ip.Equal(net.ParseIP(test.ip)) {
+					t.Errorf("Lookup(%q) = %v, %v; want %v", test.name, ip, err, test.ip)
+				}
+			} else if nil == err || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Lookup(%q) = %v, %v; want error %q", test.name, ip, err, test.err)
+			}
+		}
+		fqdn := dns.Fqdn(test.name)
+		if queries := s.count(fqdn); queries > 2 {
+			t.Errorf("%v: %v queries, second lookup not cached", test.name, queries)
+		}
+		if ttl := time.Until(r.cache[fqdn].expires); ttl > test.ttl || ttl < test.ttl-5*time.Second {
+			t.Errorf("%v: cached for %v, want %v", test.name, ttl, test.ttl)
+		}
+	}
+}
+
+func TestCNAMEs(t *testing.T) {
+	s := &testServer{
+		records: map[string][]string{
+			"wiki.example.": {
+				"wiki.example. 300 IN CNAME Wiki.Int.Corp.example.",
+				"wiki.int.corp.example. 60 IN CNAME edge.cdn.example.",
+				"edge.cdn.example. 300 IN A 192.0.2.1",
+			},
+			"www.example.": {"www.example. 300 IN A 192.0.2.2"},
+			"loop.example.": {
+				"loop.example. 300 IN CNAME a.example.",
+				"a.example. 300 IN CNAME loop.example.",
+			},
+		},
+		failing: map[string]bool{"broken.example.": true},
+	}
+	r := newTestResolver(t, s)
+	tests := []struct {
+		name  string
+		names string
+		err   bool
+		ttl   time.Duration
+	}{
+		{"wiki.example", "wiki.int.corp.example edge.cdn.example", false, 60 * time.Second},
+		{"www.example", "", false, defaultNegativeTTL},
+		{"loop.example", "a.example loop.example", false, 300 * time.Second},
+		{"missing.example", "", false, 30 * time.Second},
+		{"broken.example", "", true, failureCacheTTL},
+	}
+	for _, test := range tests {
+		for i := 0; i < 2; i++ {
+			names, err := r.CNAMEs(context.Background(), test.name)
+			if (nil != err) != test.err || strings.Join(names, " ") != test.names {
+				t.Errorf("CNAMEs(%q) = %v, %v; want %q", test.name, names, err, test.names)
+			}
+		}
+		fqdn := dns.Fqdn(test.name)
+		if queries := s.count(fqdn); 1 != queries {
+			t.Errorf("%v: %v queries, second lookup not cached", test.name, queries)
+		}
+		if ttl := time.Until(r.cnames[fqdn].expires); ttl > test.ttl || ttl < test.ttl-5*time.Second {
+			t.Errorf("%v: cached for %v, want %v", test.name, ttl, test.ttl)
+		}
+	}
+}